@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/migrations"
+)
+
+// exportPageSize is how many links export asks the storage for at a time
+const exportPageSize = 1000
+
+// linkRecord is a single row of an import or export file
+type linkRecord struct {
+	Short string `json:"short"`
+	URL   string `json:"url"`
+}
+
+type ExportCommand struct {
+	Format string `long:"format" default:"jsonl" choice:"jsonl" choice:"csv"`
+	Output string `long:"output" short:"o" default:"-"`
+}
+
+// Execute streams every link in the configured storage to the output file
+func (c *ExportCommand) Execute(args []string) error {
+	store, err := createStorageFromOption(&opts)
+	if err != nil {
+		return err
+	}
+
+	ls, ok := storage.Supports[storage.ListableStorage](store)
+	if !ok {
+		return fmt.Errorf("storage-type '%s' doesn't support listing links", opts.StorageType)
+	}
+
+	out := os.Stdout
+	if c.Output != "-" {
+		if out, err = os.Create(c.Output); err != nil {
+			return errors.Wrap(err, "failed to create output file")
+		}
+		defer out.Close()
+	}
+
+	count, err := c.export(ls, out)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d links", count)
+	return nil
+}
+
+// export writes every link in ls to out, returning how many there were
+func (c *ExportCommand) export(ls storage.ListableStorage, out io.Writer) (int, error) {
+	w, err := newRecordWriter(c.Format, out)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		cursor string
+		count  int
+	)
+	for {
+		results, next, err := ls.List(context.Background(), cursor, exportPageSize)
+		if err != nil {
+			return count, errors.Wrapf(err, "failed to list links after %q", cursor)
+		}
+
+		for _, result := range results {
+			if err := w.Write(linkRecord{Short: result.Link, URL: result.URL}); err != nil {
+				return count, errors.Wrap(err, "failed to write link")
+			}
+			count++
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if err := w.Flush(); err != nil {
+		return count, errors.Wrap(err, "failed to write links")
+	}
+
+	return count, nil
+}
+
+type ImportCommand struct {
+	Format string `long:"format" default:"jsonl" choice:"jsonl" choice:"csv"`
+	Input  string `long:"input" short:"i" default:"-"`
+	DryRun bool   `long:"dry-run"`
+	User   string `long:"user"`
+}
+
+// Execute loads every row of the input file into the configured storage through SaveName, reporting rows that were
+// rejected instead of stopping on them
+func (c *ImportCommand) Execute(args []string) error {
+	store, err := createStorageFromOption(&opts)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if c.Input != "-" {
+		if in, err = os.Open(c.Input); err != nil {
+			return errors.Wrap(err, "failed to open input file")
+		}
+		defer in.Close()
+	}
+
+	imported, rejected, err := c.importLinks(store, in)
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		log.Printf("Dry run: %d links would be imported, %d rows rejected", imported, rejected)
+	} else {
+		log.Printf("Imported %d links, %d rows rejected", imported, rejected)
+	}
+	return nil
+}
+
+// importLinks saves every valid row of in into store, unless this is a dry run, returning how many rows were (or
+// would've been) imported and how many were rejected
+func (c *ImportCommand) importLinks(store storage.NamedStorage, in io.Reader) (imported int, rejected int, err error) {
+	r, err := newRecordReader(c.Format, in)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ctx := storage.WithUser(context.Background(), c.User)
+
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = storage.ValidateLink(store, record.Short, record.URL)
+		}
+		if err == nil && !c.DryRun {
+			err = store.SaveName(ctx, record.Short, record.URL)
+		}
+
+		if _, ok := err.(readError); ok {
+			return imported, rejected, errors.Wrapf(err, "failed to read row %d", row)
+		}
+		if err != nil {
+			log.Printf("Rejected row %d (%q -> %q): %s", row, record.Short, record.URL, err)
+			rejected++
+			continue
+		}
+
+		imported++
+	}
+
+	return imported, rejected, nil
+}
+
+type recordWriter interface {
+	Write(linkRecord) error
+	Flush() error
+}
+
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	switch format {
+	case "jsonl":
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{bw, json.NewEncoder(bw)}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		return &csvWriter{w: cw}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported format: '%s'", format)
+	}
+}
+
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(record linkRecord) error { return j.enc.Encode(record) }
+func (j *jsonlWriter) Flush() error                  { return j.w.Flush() }
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(record linkRecord) error {
+	if !c.wroteHeader {
+		if err := c.w.Write([]string{"short", "url"}); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+
+	return c.w.Write([]string{record.Short, record.URL})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// readError is returned by recordReaders when the input itself can't be read, as opposed to a single bad row
+type readError struct {
+	error
+}
+
+type recordReader interface {
+	// Read returns the next row, io.EOF when there are no more rows, or a readError if reading failed entirely
+	Read() (linkRecord, error)
+}
+
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case "jsonl":
+		s := bufio.NewScanner(r)
+		s.Buffer(nil, 1024*1024)
+		return &jsonlReader{s}, nil
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported format: '%s'", format)
+	}
+}
+
+type jsonlReader struct {
+	s *bufio.Scanner
+}
+
+func (j *jsonlReader) Read() (linkRecord, error) {
+	var record linkRecord
+
+	for j.s.Scan() {
+		line := strings.TrimSpace(j.s.Text())
+		if line == "" {
+			continue
+		}
+
+		return record, json.Unmarshal([]byte(line), &record)
+	}
+	if err := j.s.Err(); err != nil {
+		return record, readError{err}
+	}
+
+	return record, io.EOF
+}
+
+type csvReader struct {
+	r *csv.Reader
+
+	shortCol, urlCol int
+	readHeader       bool
+}
+
+func (c *csvReader) Read() (linkRecord, error) {
+	if !c.readHeader {
+		header, err := c.r.Read()
+		if err == io.EOF {
+			return linkRecord{}, io.EOF
+		}
+		if err != nil {
+			return linkRecord{}, readError{errors.Wrap(err, "failed to read CSV header")}
+		}
+
+		c.shortCol, c.urlCol = -1, -1
+		for i, name := range header {
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "short":
+				c.shortCol = i
+			case "url":
+				c.urlCol = i
+			}
+		}
+		if c.shortCol < 0 || c.urlCol < 0 {
+			return linkRecord{}, readError{fmt.Errorf("CSV header must have 'short' and 'url' columns, got: %v", header)}
+		}
+		c.readHeader = true
+	}
+
+	row, err := c.r.Read()
+	if err == io.EOF {
+		return linkRecord{}, io.EOF
+	}
+	if _, ok := err.(*csv.ParseError); ok {
+		// Just this row is broken, the reader can carry on
+		return linkRecord{}, err
+	}
+	if err != nil {
+		return linkRecord{}, readError{err}
+	}
+
+	if len(row) <= c.shortCol || len(row) <= c.urlCol {
+		return linkRecord{}, fmt.Errorf("row has %d columns, expected at least %d", len(row), max(c.shortCol, c.urlCol)+1)
+	}
+
+	return linkRecord{Short: row[c.shortCol], URL: row[c.urlCol]}, nil
+}
+
+type MigrateCommand struct {
+	From       string `long:"from" required:"true"`
+	To         string `long:"to" required:"true"`
+	StartAfter string `long:"start-after"`
+}
+
+// Execute copies every link between the two storages described by --from and --to
+func (c *MigrateCommand) Execute(args []string) error {
+	from, fromType, err := createStorageFromArgs(c.From)
+	if err != nil {
+		return errors.Wrap(err, "failed to create --from storage")
+	}
+
+	to, toType, err := createStorageFromArgs(c.To)
+	if err != nil {
+		return errors.Wrap(err, "failed to create --to storage")
+	}
+
+	ls, ok := storage.Supports[storage.ListableStorage](from)
+	if !ok {
+		return fmt.Errorf("storage-type '%s' doesn't support listing links", fromType)
+	}
+
+	log.Printf("Migrating links from %s to %s", fromType, toType)
+
+	stats, err := migrations.Copy(context.Background(), ls, to, c.StartAfter)
+	if err != nil {
+		return errors.Wrapf(err, "migration stopped, re-run with --start-after=%q to resume", stats.LastShort)
+	}
+
+	log.Printf("Migration complete: %d links copied, %d already up to date", stats.Copied, stats.Skipped)
+	return nil
+}
+
+type CheckRemapsCommand struct {
+	File     string `long:"file"`
+	Examples string `long:"examples" required:"true"`
+}
+
+// Execute checks a remap file (--file, or --regex-file by default) against a file of example shorts. Each line of the
+// examples file is a short optionally followed by the URL it should be sent to; blank lines and lines starting with #
+// are ignored.
+func (c *CheckRemapsCommand) Execute(args []string) error {
+	path := c.File
+	if path == "" {
+		path = opts.Regex.File
+	}
+	if path == "" {
+		return errors.New("no remap file to check, pass --file or --regex-file")
+	}
+
+	remaps, err := storage.LoadRemapFile(path)
+	if err != nil {
+		return err
+	}
+
+	var failures int
+	for _, remap := range remaps {
+		// Check each remap on its own so every broken one is reported
+		if _, err := storage.NewRegex([]storage.Remap{remap}); err != nil {
+			log.Printf("Invalid remap: %s", err)
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d invalid remaps", failures)
+	}
+
+	r, err := storage.NewRegex(remaps)
+	if err != nil {
+		return err
+	}
+	r.Anchored = opts.Regex.Anchored
+
+	for _, shadowed := range r.Shadowed() {
+		log.Printf("Warning: remap %q looks shadowed by the earlier remap %q", shadowed.Remap.Pattern, shadowed.By.Pattern)
+	}
+
+	examples, err := os.Open(c.Examples)
+	if err != nil {
+		return errors.Wrap(err, "failed to open examples file")
+	}
+	defer examples.Close()
+
+	used := make(map[string]bool)
+	scanner := bufio.NewScanner(examples)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		short := fields[0]
+
+		remap, long, ok := r.Match(short)
+		switch {
+		case !ok:
+			log.Printf("Line %d: %q doesn't match any remap", line, short)
+			failures++
+		case len(fields) > 1 && long != fields[1]:
+			log.Printf("Line %d: %q matched %q and went to %q instead of %q", line, short, remap.Pattern, long, fields[1])
+			failures++
+		default:
+			log.Printf("Line %d: %q matched %q -> %q", line, short, remap.Pattern, long)
+		}
+		if ok {
+			used[remap.Pattern] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "failed to read examples file")
+	}
+
+	for _, remap := range remaps {
+		if !used[remap.Pattern] {
+			log.Printf("Warning: no example matched remap %q", remap.Pattern)
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d examples failed", failures)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// listAll returns every link in store as a map of short to URL
+func listAll(t *testing.T, store storage.ListableStorage) map[string]string {
+	results, _, err := store.List(context.Background(), "", 0)
+	require.Nil(t, err)
+
+	links := make(map[string]string, len(results))
+	for _, result := range results {
+		links[result.Link] = result.URL
+	}
+
+	return links
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	links := map[string]string{
+		"docs":    "https://docs.example.com",
+		"grafana": "https://grafana.example.com/d/abc?orgId=1&from=now-1h",
+		"quoted":  `https://example.com/?q="a,b"`,
+	}
+
+	for _, format := range []string{"jsonl", "csv"} {
+		format := format
+
+		t.Run(format, func(t *testing.T) {
+			from, err := storage.NewInmemFromMap(8, links)
+			require.Nil(t, err)
+
+			var exported bytes.Buffer
+			count, err := (&ExportCommand{Format: format}).export(from, &exported)
+			require.Nil(t, err)
+			assert.Equal(t, len(links), count)
+
+			to, err := storage.NewInmem(8)
+			require.Nil(t, err)
+
+			imported, rejected, err := (&ImportCommand{Format: format}).importLinks(to, &exported)
+			require.Nil(t, err)
+			assert.Equal(t, len(links), imported)
+			assert.Equal(t, 0, rejected)
+			assert.Equal(t, links, listAll(t, to))
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	testTable := []struct {
+		name     string
+		format   string
+		input    string
+		imported int
+		rejected int
+		expected map[string]string
+	}{
+		{
+			name:   "jsonl",
+			format: "jsonl",
+			input: `{"short": "docs", "url": "https://docs.example.com"}
+
+{"short": "broken", "url":
+{"short": "", "url": "https://empty.example.com"}
+{"short": "relative", "url": "/not/absolute"}
+{"short": "grafana", "url": "https://grafana.example.com"}
+{"short": "pr/{id}", "url": "https://github.com/org/repo/pull/{number}"}
+`,
+			imported: 2,
+			rejected: 4,
+			expected: map[string]string{"docs": "https://docs.example.com", "grafana": "https://grafana.example.com"},
+		},
+		{
+			name:   "csv",
+			format: "csv",
+			input: `url,short
+https://docs.example.com,docs
+https://short-row.example.com
+"https://broken.example.com,broken
+`,
+			imported: 1,
+			rejected: 2,
+			expected: map[string]string{"docs": "https://docs.example.com"},
+		},
+		{
+			name:   "csv with extra columns",
+			format: "csv",
+			input: `owner,short,url
+alice,docs,https://docs.example.com
+bob,,https://empty.example.com
+`,
+			imported: 1,
+			rejected: 1,
+			expected: map[string]string{"docs": "https://docs.example.com"},
+		},
+	}
+
+	for _, tt := range testTable {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			for _, dryRun := range []bool{false, true} {
+				store, err := storage.NewInmem(8)
+				require.Nil(t, err)
+
+				c := &ImportCommand{Format: tt.format, DryRun: dryRun}
+				imported, rejected, err := c.importLinks(store, strings.NewReader(tt.input))
+				require.Nil(t, err)
+				assert.Equal(t, tt.imported, imported, "dry run: %v", dryRun)
+				assert.Equal(t, tt.rejected, rejected, "dry run: %v", dryRun)
+
+				if dryRun {
+					assert.Empty(t, listAll(t, store), "a dry run shouldn't save anything")
+				} else {
+					assert.Equal(t, tt.expected, listAll(t, store))
+				}
+			}
+		})
+	}
+}
+
+func TestImportBadCSVHeader(t *testing.T) {
+	store, err := storage.NewInmem(8)
+	require.Nil(t, err)
+
+	_, _, err = (&ImportCommand{Format: "csv"}).importLinks(store, strings.NewReader("name,link\ndocs,https://docs.example.com\n"))
+	assert.NotNil(t, err, "a CSV without short and url columns can't be imported")
+	assert.Empty(t, listAll(t, store))
+}
+
+func TestImportDryRunMatchesStorage(t *testing.T) {
+	input := `{"short": "pr/(\\d+)", "url": "https://github.com/org/repo/pull/$1"}
+{"short": "jira/(", "url": "https://jira.example.com"}
+{"short": "docs", "url": "/not/absolute"}
+`
+
+	for _, dryRun := range []bool{true, false} {
+		regex, err := storage.NewRegexFromFile(filepath.Join(t.TempDir(), "remaps.yaml"))
+		require.Nil(t, err)
+
+		c := &ImportCommand{Format: "jsonl", DryRun: dryRun}
+		imported, rejected, err := c.importLinks(regex, strings.NewReader(input))
+		require.Nil(t, err)
+		assert.Equal(t, 1, imported, "dry run: %v", dryRun)
+		assert.Equal(t, 2, rejected, "dry run: %v", dryRun)
+	}
+}
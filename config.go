@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	multierror "github.com/hashicorp/go-multierror"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	"github.com/thomasdesr/go-shorten/handlers"
+	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/cache"
+	"github.com/thomasdesr/go-shorten/storage/multistorage"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile reads an INI file, or a YAML file if path ends in .yaml or .yml, into parser's options. Anything
+// already given on the command line wins over the file, and the file wins over environment variables. A YAML file can
+// also describe a multistorage's children as a tree under multi-children, which ends up in opts.
+func loadConfigFile(parser *flags.Parser, opts *Options, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read config file")
+		}
+
+		var config map[string]interface{}
+		if err := yaml.Unmarshal(b, &config); err != nil {
+			return errors.Wrapf(err, "failed to parse config file %s", path)
+		}
+
+		children, err := optionsFromYamlConfig(parser, config)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load config file %s", path)
+		}
+
+		opts.Multistorage.Children = children
+		return nil
+	default:
+		iniParser := flags.NewIniParser(parser)
+		iniParser.ParseAsDefaults = true
+
+		return errors.Wrapf(iniParser.ParseFile(path), "failed to load config file %s", path)
+	}
+}
+
+// optionsFromYamlConfig loads a YAML config into parser's options, returning the options of the multistorage children
+// listed under its multi-children
+func optionsFromYamlConfig(parser *flags.Parser, config map[string]interface{}) ([]*Options, error) {
+	rawChildren, err := popMultiChildren(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ini, err := yamlToIni(parser, config)
+	if err != nil {
+		return nil, err
+	}
+
+	iniParser := flags.NewIniParser(parser)
+	iniParser.ParseAsDefaults = true
+	if err := iniParser.Parse(bytes.NewReader(ini)); err != nil {
+		return nil, err
+	}
+
+	children := make([]*Options, 0, len(rawChildren))
+	for i, rawChild := range rawChildren {
+		var child Options
+		childParser := flags.NewParser(&child, flags.None)
+		if _, err := childParser.ParseArgs(nil); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse multi-children #%d", i)
+		}
+
+		grandchildren, err := optionsFromYamlConfig(childParser, rawChild)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse multi-children #%d", i)
+		}
+
+		child.Multistorage.Children = grandchildren
+		children = append(children, &child)
+	}
+
+	return children, nil
+}
+
+// popMultiChildren removes multi-children from a YAML config, either at the top level or in the multistorage group,
+// and returns each child's config
+func popMultiChildren(config map[string]interface{}) ([]map[string]interface{}, error) {
+	const key = "multi-children"
+
+	raw, ok := config[key]
+	delete(config, key)
+	if section, isSection := config["Multi Storage Options"].(map[string]interface{}); isSection {
+		if sectionRaw, inSection := section[key]; inSection {
+			raw, ok = sectionRaw, true
+			delete(section, key)
+		}
+	}
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of storages", key)
+	}
+
+	children := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of storages, got: %v", key, item)
+		}
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+// yamlToIni converts a YAML config into the INI that go-flags reads. Top level keys are option names (e.g.
+// storage-type), or group names (e.g. "S3 Storage Options") holding option names. Lists set an option once per item
+// and mappings fill in map options like regex-remap.
+func yamlToIni(parser *flags.Parser, config map[string]interface{}) ([]byte, error) {
+	var global, groups bytes.Buffer
+	for _, key := range sortedKeys(config) {
+		section, isSection := config[key].(map[string]interface{})
+		if !isSection || parser.FindOptionByLongName(key) != nil {
+			if err := writeIniOption(&global, key, config[key]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		fmt.Fprintf(&groups, "[%s]\n", key)
+		for _, name := range sortedKeys(section) {
+			if err := writeIniOption(&groups, name, section[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Options outside of a section have to come first
+	return append(global.Bytes(), groups.Bytes()...), nil
+}
+
+func writeIniOption(buf *bytes.Buffer, name string, value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if err := writeIniOption(buf, name, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			fmt.Fprintf(buf, "%s = %s\n", name, strconv.Quote(fmt.Sprintf("%s:%v", key, value[key])))
+		}
+	case nil:
+		fmt.Fprintf(buf, "%s =\n", name)
+	case string, bool, int, float64:
+		fmt.Fprintf(buf, "%s = %s\n", name, strconv.Quote(fmt.Sprint(value)))
+	default:
+		return fmt.Errorf("unsupported value for %s: %v", name, value)
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// reloadOnHangup reloads the parts of the configuration that are safe to change while serving whenever we get a
+// SIGHUP: regex remap files, regex remaps given in the config file (or on the command line) and templates. Everything
+// else needs a restart. store is what's serving links, backend is the storage underneath any cache in front of it.
+func reloadOnHangup(store storage.Storage, backend storage.Storage) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		log.Println("Got SIGHUP, reloading")
+
+		if rs, ok := backend.(storage.ReloadableStorage); ok {
+			if err := rs.Reload(context.Background()); err != nil {
+				log.Printf("Failed to reload storage: %s", err)
+			}
+		}
+
+		if opts.Config != "" {
+			if err := reloadConfigFile(backend); err != nil {
+				log.Printf("Failed to reload config file: %s", err)
+			}
+		}
+
+		if c, ok := store.(*cache.Cache); ok {
+			c.Purge() // Anything cached might've been remapped
+		}
+
+		if err := handlers.ReloadTemplates(); err != nil {
+			log.Printf("Failed to reload templates: %s", err)
+		}
+	}
+}
+
+// reloadConfigFile reads the options again, from the command line and the config file, and applies their regex remaps
+// to the regex storages in backend
+func reloadConfigFile(backend storage.Storage) error {
+	var reloaded Options
+	parser := flags.NewParser(&reloaded, flags.IgnoreUnknown)
+	if _, err := parser.ParseArgs(os.Args[1:]); err != nil {
+		return errors.Wrap(err, "failed to parse the command line")
+	}
+	if err := loadConfigFile(parser, &reloaded, opts.Config); err != nil {
+		return err
+	}
+
+	return reloadRemaps(backend, &reloaded)
+}
+
+// reloadRemaps applies the --regex-remap remaps in opts to the regex storages in store, which was created from an
+// earlier version of opts. Remaps added to a file backed regex storage are saved into the file, like they are when
+// starting up, while a regex storage without a file has its remaps replaced.
+func reloadRemaps(store storage.Storage, opts *Options) error {
+	switch s := store.(type) {
+	case *storage.Regex:
+		if strings.ToLower(opts.StorageType) != "regex" {
+			return fmt.Errorf("storage-type changed to '%s', restart to change it", opts.StorageType)
+		}
+		if opts.Regex.File == "" {
+			return s.ReplaceRemaps(opts.Regex.Remaps)
+		}
+
+		for regex, replacement := range opts.Regex.Remaps {
+			if err := s.SaveName(context.Background(), regex, replacement); err != nil {
+				return errors.Wrapf(err, "failed to add remap %q", regex)
+			}
+		}
+		return nil
+	case *multistorage.MultiStorage:
+		children, err := opts.multistorageChildren()
+		if err != nil {
+			return err
+		}
+
+		stores := s.Stores()
+		if len(children) != len(stores) {
+			return fmt.Errorf("multistorage has %d children instead of %d, restart to change them", len(children), len(stores))
+		}
+
+		errs := new(multierror.Error)
+		for i, child := range children {
+			if err := reloadRemaps(stores[i], child); err != nil {
+				multierror.Append(errs, errors.Wrapf(err, "failed to reload storage #%d", i))
+			}
+		}
+		return errs.ErrorOrNil()
+	default:
+		return nil
+	}
+}
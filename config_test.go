@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// storageTree is the part of a storage's options that decides how a multistorage is put together
+type storageTree struct {
+	storageType string
+	readOnly    bool
+	loader      string
+	saver       string
+	quorum      int
+	children    []storageTree
+}
+
+// loadYamlConfig loads config like --config would, after parsing args from the command line
+func loadYamlConfig(t *testing.T, config string, args ...string) (*Options, error) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.Nil(t, os.WriteFile(path, []byte(config), 0o644))
+
+	var opts Options
+	parser := flags.NewParser(&opts, flags.None)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+
+	return &opts, loadConfigFile(parser, &opts, path)
+}
+
+func treeFromOptions(t *testing.T, opts *Options) storageTree {
+	tree := storageTree{
+		storageType: opts.StorageType,
+		readOnly:    opts.Multistorage.ReadOnly,
+	}
+	if opts.StorageType != "multistorage" {
+		return tree
+	}
+
+	children, err := opts.multistorageChildren()
+	require.Nil(t, err)
+
+	var readOnly []int
+	for i, child := range children {
+		if child.Multistorage.ReadOnly {
+			readOnly = append(readOnly, i)
+		}
+		tree.children = append(tree.children, treeFromOptions(t, child))
+	}
+
+	_, err = opts.multistorageOptions(len(children), readOnly)
+	assert.Nil(t, err, "multistorageOptions")
+
+	tree.loader, tree.saver = opts.Multistorage.Loader, opts.Multistorage.Saver
+	tree.quorum = opts.multistorageQuorum(len(children))
+
+	return tree
+}
+
+func TestYamlMultistorageConfig(t *testing.T) {
+	testTable := []struct {
+		name     string
+		config   string
+		args     []string
+		expected storageTree
+	}{
+		{
+			name: "defaults",
+			config: `
+storage-type: multistorage
+multi-children:
+  - storage-type: inmem
+  - storage-type: filesystem
+  - storage-type: inmem
+`,
+			expected: storageTree{
+				storageType: "multistorage", loader: "first", saver: "all", quorum: 2,
+				children: []storageTree{{storageType: "inmem"}, {storageType: "filesystem"}, {storageType: "inmem"}},
+			},
+		},
+		{
+			name: "top level options",
+			config: `
+storage-type: multistorage
+multi-loader: quorum
+multi-saver: once
+multi-children:
+  - storage-type: regex
+    multi-read-only: true
+  - storage-type: inmem
+`,
+			expected: storageTree{
+				storageType: "multistorage", loader: "quorum", saver: "once", quorum: 2,
+				children: []storageTree{{storageType: "regex", readOnly: true}, {storageType: "inmem"}},
+			},
+		},
+		{
+			name: "group options",
+			config: `
+storage-type: multistorage
+Multi Storage Options:
+  multi-loader: quorum
+  multi-quorum: 1
+  multi-children:
+    - storage-type: inmem
+    - storage-type: inmem
+      multi-read-only: true
+`,
+			expected: storageTree{
+				storageType: "multistorage", loader: "quorum", saver: "all", quorum: 1,
+				children: []storageTree{{storageType: "inmem"}, {storageType: "inmem", readOnly: true}},
+			},
+		},
+		{
+			name: "nested multistorage",
+			config: `
+storage-type: multistorage
+multi-loader: first-parallel
+multi-children:
+  - storage-type: multistorage
+    multi-read-only: true
+    multi-loader: compare-all
+    multi-saver: once
+    multi-children:
+      - storage-type: inmem
+      - storage-type: regex
+        multi-read-only: true
+  - storage-type: filesystem
+`,
+			expected: storageTree{
+				storageType: "multistorage", loader: "first-parallel", saver: "all", quorum: 2,
+				children: []storageTree{
+					{
+						storageType: "multistorage", readOnly: true, loader: "compare-all", saver: "once", quorum: 2,
+						children: []storageTree{{storageType: "inmem"}, {storageType: "regex", readOnly: true}},
+					},
+					{storageType: "filesystem"},
+				},
+			},
+		},
+		{
+			name: "sub args come first",
+			config: `
+multi-children:
+  - storage-type: filesystem
+    multi-read-only: true
+`,
+			args: []string{"--storage-type", "multistorage", "--multi-sub-args=--storage-type inmem --multi-read-only", "--multi-loader", "quorum"},
+			expected: storageTree{
+				storageType: "multistorage", loader: "quorum", saver: "all", quorum: 2,
+				children: []storageTree{{storageType: "inmem", readOnly: true}, {storageType: "filesystem", readOnly: true}},
+			},
+		},
+	}
+
+	for _, tt := range testTable {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := loadYamlConfig(t, tt.config, tt.args...)
+			require.Nil(t, err)
+
+			assert.Equal(t, tt.expected, treeFromOptions(t, opts))
+		})
+	}
+}
+
+func TestYamlMultistorageConfigErrors(t *testing.T) {
+	_, err := loadYamlConfig(t, "multi-children: inmem\n")
+	assert.NotNil(t, err, "multi-children has to be a list")
+
+	_, err = loadYamlConfig(t, "multi-children:\n  - inmem\n")
+	assert.NotNil(t, err, "multi-children has to be a list of storages")
+
+	_, err = loadYamlConfig(t, "multi-children:\n  - storage-type: inmem\n    multi-loader: sometimes\n")
+	assert.NotNil(t, err, "children's options should be checked")
+}
+
+func TestReadOnlyOutsideMultistorage(t *testing.T) {
+	opts, err := loadYamlConfig(t, "storage-type: inmem\nmulti-read-only: true\n")
+	require.Nil(t, err)
+
+	_, err = createStorageFromOption(opts)
+	assert.Equal(t, errReadOnlyOutsideMultistorage, errors.Cause(err))
+
+	opts, err = loadYamlConfig(t, `
+storage-type: multistorage
+multi-children:
+  - storage-type: inmem
+    multi-read-only: true
+  - storage-type: inmem
+`)
+	require.Nil(t, err)
+
+	_, err = createStorageFromOption(opts)
+	assert.Nil(t, err, "children can be read-only")
+}
+
+func TestReloadRemaps(t *testing.T) {
+	remapFile := filepath.Join(t.TempDir(), "remaps.yaml")
+	opts, err := loadYamlConfig(t, `
+storage-type: multistorage
+multi-children:
+  - storage-type: regex
+    regex-remap:
+      docs: https://docs.example.com
+  - storage-type: regex
+    regex-file: `+remapFile+`
+  - storage-type: inmem
+`)
+	require.Nil(t, err)
+	backend, err := createStorageFromOption(opts)
+	require.Nil(t, err)
+
+	reloaded, err := loadYamlConfig(t, `
+storage-type: multistorage
+multi-children:
+  - storage-type: regex
+    regex-remap:
+      wiki: https://wiki.example.com
+  - storage-type: regex
+    regex-file: `+remapFile+`
+    regex-remap:
+      pr/(\d+): https://github.com/org/repo/pull/$1
+  - storage-type: inmem
+`)
+	require.Nil(t, err)
+	require.Nil(t, reloadRemaps(backend, reloaded))
+
+	long, err := backend.Load(context.Background(), "wiki")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://wiki.example.com", long)
+	_, err = backend.Load(context.Background(), "docs")
+	assert.Equal(t, storage.ErrShortNotSet, errors.Cause(err), "remaps removed from the config should be dropped")
+
+	long, err = backend.Load(context.Background(), "pr/12")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/org/repo/pull/12", long)
+	remaps, err := storage.LoadRemapFile(remapFile)
+	assert.Nil(t, err)
+	assert.Len(t, remaps, 1, "remaps for a regex file should be saved into it")
+
+	changed, err := loadYamlConfig(t, `
+storage-type: multistorage
+multi-children:
+  - storage-type: inmem
+  - storage-type: regex
+    regex-file: `+remapFile+`
+  - storage-type: inmem
+`)
+	require.Nil(t, err)
+	assert.NotNil(t, reloadRemaps(backend, changed), "changing a storage's type needs a restart")
+
+	long, err = backend.Load(context.Background(), "wiki")
+	assert.Nil(t, err, "remaps should be left alone when the storages changed")
+	assert.Equal(t, "https://wiki.example.com", long)
+}
@@ -0,0 +1,2 @@
+ALTER TABLE links ADD COLUMN owner TEXT DEFAULT '' NOT NULL;
+ALTER TABLE links ADD COLUMN last_editor TEXT DEFAULT '' NOT NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE links_history (
+    id          SERIAL      NOT NULL,
+    link        TEXT        NOT NULL,
+    url         TEXT        NOT NULL,
+    username    TEXT        DEFAULT '' NOT NULL,
+    changed_at  TIMESTAMPTZ DEFAULT now() NOT NULL,
+
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX links_history_link_idx ON links_history (link);
@@ -0,0 +1,3 @@
+-- Rows from before this migration only know the day they were last accessed
+ALTER TABLE links_usage ADD COLUMN last_accessed TIMESTAMPTZ;
+ALTER TABLE links_usage ALTER COLUMN last_accessed SET DEFAULT now();
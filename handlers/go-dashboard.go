@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"html/template"
 	"log"
 	"net/http"
 )
@@ -9,7 +8,7 @@ import (
 var goDashboardPath = "static/templates/go-dashboard.tmpl"
 
 func ServeGoDashboard() http.Handler {
-	t, err := template.ParseFiles(goDashboardPath, searchPath)
+	t, err := ParseTemplateFiles(goDashboardPath, searchPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,7 +2,6 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,18 +10,17 @@ import (
 	"github.com/thomasdesr/go-shorten/storage"
 )
 
+// Healthcheck checks that store answers a lookup of path. It only reads, so it doesn't matter whether path is set, and
+// stores like a file backed regex storage don't get a link written into them just for the check.
 func Healthcheck(store storage.Storage, path string) http.Handler {
-	if s, ok := store.(storage.NamedStorage); ok {
-		s.SaveName(context.Background(), path, "https://google.com")
-	}
-
 	return instrumentHandler("healthcheck", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := store.Load(r.Context(), path)
-		if err != nil {
+		_, err := store.Load(storage.WithoutVisit(r.Context()), path)
+		switch errors.Cause(err) {
+		case nil, storage.ErrShortNotSet, storage.ErrFuzzyMatchFound:
+			w.WriteHeader(http.StatusOK)
+		default:
 			http.Error(w, "healtcheck fail", http.StatusInternalServerError)
-			return
 		}
-		w.WriteHeader(http.StatusOK)
 	}))
 }
 
@@ -38,12 +36,12 @@ func GetShort(store storage.Storage, index Index) http.Handler {
 		index.Short = short
 
 		url, err := store.Load(r.Context(), short)
-		switch err := errors.Cause(err); err {
+		switch errors.Cause(err) {
 		case nil:
 			http.Redirect(w, r, url, http.StatusFound)
 			return
 		case storage.ErrFuzzyMatchFound:
-			index.Fuzzy = url
+			index.Suggestions = storage.Suggestions(err)
 			w.WriteHeader(http.StatusNotFound)
 		case storage.ErrShortNotSet:
 			index.Error = fmt.Errorf("The link you specified does not exist. You can create it below.")
@@ -57,17 +55,12 @@ func GetShort(store storage.Storage, index Index) http.Handler {
 	}))
 }
 
-func SetShort(store storage.NamedStorage) http.Handler {
+// SetShort saves a short from a form or query, generating one when none is given. backend is store without any cache in
+// front of it and says whether shorts can be generated.
+func SetShort(store storage.NamedStorage, backend storage.Storage) http.Handler {
 	return instrumentHandler("set_short", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		short, err := getShortFromRequest(r)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if short == "" {
-			http.Error(w, "Missing short name", http.StatusBadRequest)
-			return
-		}
+		// A missing short is fine as long as the store can generate one for us
+		short, _ := getShortFromRequest(r)
 
 		url, err := getURLFromRequest(r)
 		if err != nil {
@@ -75,10 +68,24 @@ func SetShort(store storage.NamedStorage) http.Handler {
 			return
 		}
 
-		err = store.SaveName(r.Context(), short, url)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to save '%s' to '%s' because: %s", url, short, err), http.StatusInternalServerError)
-			return
+		if short == "" {
+			unnamed, ok := store.(storage.UnnamedStorage)
+			if _, generates := backend.(storage.UnnamedStorage); !ok || !generates {
+				http.Error(w, "Missing short name", http.StatusBadRequest)
+				return
+			}
+
+			short, err = unnamed.Save(r.Context(), url)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save '%s' because: %s", url, err), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			err = store.SaveName(r.Context(), short, url)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save '%s' to '%s' because: %s", url, short, err), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		// Return the short code formatted based on Accept headers
@@ -100,3 +107,23 @@ func SetShort(store storage.NamedStorage) http.Handler {
 		}
 	}))
 }
+
+func DeleteShort(store storage.DeletableStorage) http.Handler {
+	return instrumentHandler("delete_short", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		short, err := getShortFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = store.Delete(r.Context(), short)
+		switch errors.Cause(err) {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case storage.ErrShortNotSet:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, fmt.Sprintf("Failed to delete '%s' because: %s", short, err), http.StatusInternalServerError)
+		}
+	}))
+}
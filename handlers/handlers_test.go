@@ -0,0 +1,45 @@
+package handlers_test
+
+import (
+	"context"
+
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/handlers"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// brokenStorage fails every load
+type brokenStorage struct{}
+
+func (brokenStorage) Load(ctx context.Context, short string) (string, error) {
+	return "", errors.New("storage is down")
+}
+
+func TestHealthcheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remaps.yaml")
+	remaps := []byte("- pattern: pr/(\\d+)\n  replacement: https://github.com/org/repo/pull/$1\n")
+	require.Nil(t, os.WriteFile(path, remaps, 0o644))
+
+	regex, err := storage.NewRegexFromFile(path)
+	require.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	handlers.Healthcheck(regex, "/healthcheck").ServeHTTP(w, httptest.NewRequest("GET", "/healthcheck", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "a store without the healthcheck link is still healthy")
+
+	written, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, string(remaps), string(written), "the healthcheck shouldn't write to the remap file")
+
+	w = httptest.NewRecorder()
+	handlers.Healthcheck(brokenStorage{}, "/healthcheck").ServeHTTP(w, httptest.NewRequest("GET", "/healthcheck", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
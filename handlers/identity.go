@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// IdentityExtractor works out who made a request, returning "" when it can't tell
+type IdentityExtractor func(r *http.Request) string
+
+// HeaderIdentity trusts the first non-empty header out of headers, e.g. X-Forwarded-User set by an auth proxy. Only use
+// it when every request passes through a proxy that won't let clients set these headers themselves.
+func HeaderIdentity(headers ...string) IdentityExtractor {
+	return func(r *http.Request) string {
+		for _, header := range headers {
+			if user := strings.TrimSpace(r.Header.Get(header)); user != "" {
+				return user
+			}
+		}
+
+		return ""
+	}
+}
+
+// WithIdentity puts the user found by extract into the request context so the storage layer can record who made a change
+func WithIdentity(extract IdentityExtractor, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := extract(r); user != "" {
+			r = r.WithContext(storage.WithUser(r.Context(), user))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
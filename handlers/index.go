@@ -1,22 +1,24 @@
 package handlers
 
 import (
-	"html/template"
 	"net/http"
+
+	"github.com/thomasdesr/go-shorten/storage"
 )
 
 type Index struct {
-	Short    string
-	Error    error
-	Fuzzy    string
-	Template *template.Template
+	Short string
+	Error error
+	// Suggestions are similar shorts to the one that wasn't found, best first
+	Suggestions []storage.Suggestion
+	Template    *Template
 }
 
 var defaultIndexPath = "static/templates/index.tmpl"
 var searchPath = "static/templates/search.tmpl"
 
 func NewIndex(path string) (Index, error) {
-	t, err := template.ParseFiles(path, searchPath)
+	t, err := ParseTemplateFiles(path, searchPath)
 	if err != nil {
 		return Index{}, err
 	}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// Link is the API representation of a short and the URL it points at
+type Link struct {
+	Short      string `json:"short"`
+	URL        string `json:"url"`
+	Owner      string `json:"owner,omitempty"`
+	LastEditor string `json:"last_editor,omitempty"`
+}
+
+// addMetadata fills in link's metadata if backend keeps track of it. Handlers that also take a store are given the
+// backend separately, since a cache in front of it only stands in for loading and changing links. Links without any
+// metadata recorded, e.g. ones a regex or parameterized link resolved, are left without an owner or last editor.
+func addMetadata(r *http.Request, backend storage.Storage, link *Link) error {
+	ms, ok := backend.(storage.MetadataStorage)
+	if !ok {
+		return nil
+	}
+
+	md, err := ms.Metadata(r.Context(), link.Short)
+	if errors.Cause(err) == storage.ErrShortNotSet {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link.Owner = md.Owner
+	link.LastEditor = md.LastEditor
+	return nil
+}
+
+// GetLink returns what a short points at, without counting it as a visit to the link
+func GetLink(store storage.Storage, backend storage.Storage) http.Handler {
+	return instrumentHandler("api/links/get", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		short := httprouter.ParamsFromContext(r.Context()).ByName("short")
+
+		url, err := store.Load(storage.WithoutVisit(r.Context()), short)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		link := Link{Short: short, URL: url}
+		if err := addMetadata(r, backend, &link); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, link)
+	}))
+}
+
+// PutLink creates or updates the short named in the path
+func PutLink(store storage.NamedStorage, backend storage.Storage) http.Handler {
+	return instrumentHandler("api/links/put", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var link Link
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			writeJSONError(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid request body: %s", err)})
+			return
+		}
+		link.Short = httprouter.ParamsFromContext(r.Context()).ByName("short")
+
+		if err := store.SaveName(r.Context(), link.Short, link.URL); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if err := addMetadata(r, backend, &link); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, link)
+	}))
+}
+
+// linkExists returns whether backend already has short, without counting it as a visit
+func linkExists(r *http.Request, backend storage.Storage, short string) (bool, error) {
+	var err error
+	if es, ok := backend.(storage.ExactStorage); ok {
+		_, err = es.LoadExact(r.Context(), short)
+	} else {
+		_, err = backend.Load(storage.WithoutVisit(r.Context()), short)
+	}
+
+	switch errors.Cause(err) {
+	case nil:
+		return true, nil
+	case storage.ErrShortNotSet, storage.ErrFuzzyMatchFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// CreateLink creates or updates a short from the request body, generating a short when none is given and the store
+// supports it. It responds 201 when the short is new and 200 when an existing short was changed.
+func CreateLink(store storage.NamedStorage, backend storage.Storage) http.Handler {
+	return instrumentHandler("api/links/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var link Link
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			writeJSONError(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid request body: %s", err)})
+			return
+		}
+
+		var (
+			existed bool
+			err     error
+		)
+		if link.Short == "" {
+			unnamed, ok := store.(storage.UnnamedStorage)
+			if _, generates := backend.(storage.UnnamedStorage); !ok || !generates {
+				writeJSONError(w, http.StatusBadRequest, apiError{Error: "missing short name"})
+				return
+			}
+
+			link.Short, err = unnamed.Save(r.Context(), link.URL)
+		} else {
+			if existed, err = linkExists(r, backend, link.Short); err != nil {
+				writeStorageError(w, err)
+				return
+			}
+
+			err = store.SaveName(r.Context(), link.Short, link.URL)
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if err := addMetadata(r, backend, &link); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		if existed {
+			writeJSON(w, http.StatusOK, link)
+			return
+		}
+
+		short := link.Short
+		if cs, ok := backend.(storage.CanonicalStorage); ok {
+			if short, err = cs.CanonicalShort(short); err != nil {
+				writeStorageError(w, err)
+				return
+			}
+		}
+
+		w.Header().Set("Location", "/_api/v1/links/"+url.PathEscape(short))
+		writeJSON(w, http.StatusCreated, link)
+	}))
+}
+
+func GetLinkHistory(store storage.HistoryStorage) http.Handler {
+	return instrumentHandler("api/links/history", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		short := httprouter.ParamsFromContext(r.Context()).ByName("short")
+
+		history, err := store.History(r.Context(), short)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, history)
+	}))
+}
+
+const defaultStatsDays = 30
+
+// GetLinkStats returns how much a short has been used, ?days= sets how many days before today to include
+func GetLinkStats(store storage.StatsStorage) http.Handler {
+	return instrumentHandler("api/links/stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		short := httprouter.ParamsFromContext(r.Context()).ByName("short")
+
+		days := defaultStatsDays
+		if rawDays := r.URL.Query().Get("days"); rawDays != "" {
+			var err error
+			if days, err = strconv.Atoi(rawDays); err != nil || days < 0 {
+				writeJSONError(w, http.StatusBadRequest, apiError{Error: "days must be a non-negative integer"})
+				return
+			}
+		}
+
+		stats, err := store.Stats(r.Context(), short, days)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+	}))
+}
+
+// Reverter is the part of storage.RevertableStorage that RevertLink needs, which a cache in front of one has too
+type Reverter interface {
+	storage.Storage
+	Revert(ctx context.Context, short string, revision string) error
+}
+
+// RevertLink points a short back at the URL from one of its earlier revisions
+func RevertLink(store Reverter, backend storage.Storage) http.Handler {
+	return instrumentHandler("api/links/revert", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Revision string `json:"revision"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, apiError{Error: fmt.Sprintf("invalid request body: %s", err)})
+			return
+		}
+
+		link := Link{Short: httprouter.ParamsFromContext(r.Context()).ByName("short")}
+		if err := store.Revert(r.Context(), link.Short, body.Revision); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		var err error
+		if link.URL, err = store.Load(storage.WithoutVisit(r.Context()), link.Short); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if err := addMetadata(r, backend, &link); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, link)
+	}))
+}
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// ListLinks returns a page of links, the returned next_cursor can be passed back as ?cursor= to get the next page
+func ListLinks(store storage.ListableStorage) http.Handler {
+	return instrumentHandler("api/links/list", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultListLimit
+		if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+			var err error
+			if limit, err = strconv.Atoi(rawLimit); err != nil || limit <= 0 {
+				writeJSONError(w, http.StatusBadRequest, apiError{Error: "limit must be a positive integer"})
+				return
+			}
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+
+		results, next, err := store.List(r.Context(), r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		page := struct {
+			Links      []Link `json:"links"`
+			NextCursor string `json:"next_cursor"`
+		}{
+			Links:      make([]Link, 0, len(results)),
+			NextCursor: next,
+		}
+		for _, result := range results {
+			page.Links = append(page.Links, Link{Short: result.Link, URL: result.URL})
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}))
+}
@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/handlers"
+	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/multistorage"
+)
+
+// newLinksAPI routes the links API to store like main does, without a cache in front of it
+func newLinksAPI(store storage.NamedStorage) http.Handler {
+	r := httprouter.New()
+	r.Handler("GET", "/_api/v1/links/:short", handlers.GetLink(store, store))
+	r.Handler("PUT", "/_api/v1/links/:short", handlers.PutLink(store, store))
+	r.Handler("POST", "/_api/v1/links", handlers.CreateLink(store, store))
+
+	return r
+}
+
+// request sends method to path on api with body encoded as JSON (unless it's nil), decoding the response's JSON into
+// a Link
+func request(t *testing.T, api http.Handler, method string, path string, body interface{}) (*httptest.ResponseRecorder, handlers.Link) {
+	var buf bytes.Buffer
+	if body != nil {
+		require.Nil(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+
+	var link handlers.Link
+	if w.Code < 300 {
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&link), w.Body.String())
+	}
+
+	return w, link
+}
+
+func TestGetLinkWithoutMetadata(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{`jira-(\d+)`: "https://jira.example.com/browse/JIRA-$1"})
+	require.Nil(t, err)
+	inmem, err := storage.NewInmem(8)
+	require.Nil(t, err)
+	store, err := multistorage.Simple(regex, inmem)
+	require.Nil(t, err)
+
+	w, link := request(t, newLinksAPI(store), "GET", "/_api/v1/links/jira-5", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, handlers.Link{Short: "jira-5", URL: "https://jira.example.com/browse/JIRA-5"}, link)
+}
+
+func TestCreateParameterizedLink(t *testing.T) {
+	store, err := storage.NewInmem(8)
+	require.Nil(t, err)
+	api := newLinksAPI(store)
+
+	w, link := request(t, api, "POST", "/_api/v1/links", handlers.Link{Short: "pr/{id}", URL: "https://github.com/org/repo/pull/{id}"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "pr/{id}", link.Short)
+
+	long, err := store.Load(context.Background(), "pr/12")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/org/repo/pull/12", long)
+}
+
+func TestCreateLink(t *testing.T) {
+	store, err := storage.NewInmem(8)
+	require.Nil(t, err)
+	api := newLinksAPI(store)
+
+	w, link := request(t, api, "POST", "/_api/v1/links", handlers.Link{Short: "Go Docs", URL: "https://docs.example.com"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "/_api/v1/links/godocs", w.Header().Get("Location"), "Location should use the short as it's saved")
+	assert.Equal(t, handlers.Link{Short: "Go Docs", URL: "https://docs.example.com"}, link)
+
+	w, link = request(t, api, "POST", "/_api/v1/links", handlers.Link{Short: "go-docs", URL: "https://docs.example.com/v2"})
+	assert.Equal(t, http.StatusOK, w.Code, "changing an existing link: %s", w.Body.String())
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.Equal(t, "https://docs.example.com/v2", link.URL)
+
+	w, link = request(t, api, "POST", "/_api/v1/links", handlers.Link{URL: "https://random.example.com"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.NotEmpty(t, link.Short, "a short should be generated when none is given")
+	assert.Equal(t, "/_api/v1/links/"+link.Short, w.Header().Get("Location"))
+
+	w, _ = request(t, api, "POST", "/_api/v1/links", handlers.Link{Short: "relative", URL: "/not/absolute"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateLinkWithoutGeneratingShorts(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{})
+	require.Nil(t, err)
+
+	w, _ := request(t, newLinksAPI(regex), "POST", "/_api/v1/links", handlers.Link{URL: "https://random.example.com"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "a short is needed when the store can't generate one")
+}
+
+func TestGetLink(t *testing.T) {
+	store, err := storage.NewInmemFromMap(8, map[string]string{"docs": "https://docs.example.com"})
+	require.Nil(t, err)
+	api := newLinksAPI(store)
+
+	for i := 0; i < 2; i++ {
+		w, link := request(t, api, "GET", "/_api/v1/links/docs", nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, handlers.Link{Short: "docs", URL: "https://docs.example.com"}, link)
+	}
+
+	top, err := store.TopNForPeriod(context.Background(), 10, 1)
+	assert.Nil(t, err)
+	assert.Empty(t, top, "reading a link through the API isn't a visit")
+
+	_, err = store.Load(context.Background(), "docs")
+	require.Nil(t, err)
+	top, err = store.TopNForPeriod(context.Background(), 10, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []storage.TopNResult{{Link: "docs", HitCount: 1}}, top, "following the link is")
+
+	w, _ := request(t, api, "GET", "/_api/v1/links/missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPutLink(t *testing.T) {
+	store, err := storage.NewInmem(8)
+	require.Nil(t, err)
+	api := newLinksAPI(store)
+
+	w, link := request(t, api, "PUT", "/_api/v1/links/docs", handlers.Link{URL: "https://docs.example.com"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, handlers.Link{Short: "docs", URL: "https://docs.example.com"}, link)
+
+	long, err := store.Load(context.Background(), "docs")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://docs.example.com", long)
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest("PUT", "/_api/v1/links/docs", bytes.NewBufferString("{")))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "a body that isn't JSON should be rejected")
+}
@@ -7,6 +7,18 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// register registers c in the standard registry, or returns the collector that's already registered in its place
+func register[C prometheus.Collector](c C) C {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector.(C)
+		}
+		panic(err)
+	}
+
+	return c
+}
+
 func instrumentHandler(handleName string, next http.Handler) http.Handler {
 	inFlightGauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Subsystem:   "http",
@@ -58,14 +70,12 @@ func instrumentHandler(handleName string, next http.Handler) http.Handler {
 		[]string{"code", "method"},
 	)
 
-	// Register all of the metrics in the standard registry.
-	prometheus.MustRegister(
-		inFlightGauge,
-		requestCounter,
-		requestDuration,
-		requestSize,
-		responseSize,
-	)
+	// Register all of the metrics in the standard registry, sharing them with any earlier handler of the same name
+	inFlightGauge = register(inFlightGauge)
+	requestCounter = register(requestCounter)
+	requestDuration = register(requestDuration)
+	requestSize = register(requestSize)
+	responseSize = register(responseSize)
 
 	return promhttp.InstrumentHandlerInFlight(inFlightGauge,
 		promhttp.InstrumentHandlerCounter(requestCounter,
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"html/template"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Template is an html/template parsed from files, which can be re-parsed with Reload while it's being served
+type Template struct {
+	files []string
+
+	t  *template.Template
+	mu sync.RWMutex
+}
+
+var (
+	// templates are all of the templates that ReloadTemplates reloads
+	templates   []*Template
+	templatesMu sync.Mutex
+)
+
+// ParseTemplateFiles parses files into a Template
+func ParseTemplateFiles(files ...string) (*Template, error) {
+	t := &Template{files: files}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+
+	templatesMu.Lock()
+	templates = append(templates, t)
+	templatesMu.Unlock()
+
+	return t, nil
+}
+
+func (t *Template) Execute(w io.Writer, data interface{}) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.t.Execute(w, data)
+}
+
+// Reload re-parses t's files, keeping the current template if they don't parse
+func (t *Template) Reload() error {
+	parsed, err := template.ParseFiles(t.files...)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.t = parsed
+	t.mu.Unlock()
+
+	return nil
+}
+
+// ReloadTemplates reloads every template created with ParseTemplateFiles
+func ReloadTemplates() error {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+
+	var errs error
+	for _, t := range templates {
+		if err := t.Reload(); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "failed to reload %v", t.files))
+		}
+	}
+
+	return errs
+}
@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/thomasdesr/go-shorten/storage"
 )
 
 func getShortFromRequest(r *http.Request) (short string, err error) {
@@ -24,3 +30,64 @@ func getURLFromRequest(r *http.Request) (url string, err error) {
 
 	return "", fmt.Errorf("failed to find short in request")
 }
+
+// apiError is the body returned by every JSON API endpoint on failure
+type apiError struct {
+	Error string `json:"error"`
+	// Suggestion is the best of Suggestions, kept for clients from before there were several
+	Suggestion  string               `json:"suggestion,omitempty"`
+	Suggestions []storage.Suggestion `json:"suggestions,omitempty"`
+}
+
+// suggestionsError is the not found error for a short that has similar shorts
+func suggestionsError(err error) apiError {
+	body := apiError{
+		Error:       storage.ErrShortNotSet.Error(),
+		Suggestions: storage.Suggestions(err),
+	}
+	if len(body.Suggestions) > 0 {
+		body.Suggestion = body.Suggestions[0].Short
+	}
+
+	return body
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to render JSON: %s", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, body apiError) {
+	writeJSON(w, status, body)
+}
+
+// writeStorageError maps errors returned by the storage layer to an API error response
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch cause := errors.Cause(err); cause {
+	case storage.ErrShortNotSet, storage.ErrRevisionNotFound:
+		writeJSONError(w, http.StatusNotFound, apiError{Error: cause.Error()})
+	case storage.ErrFuzzyMatchFound:
+		writeJSONError(w, http.StatusNotFound, suggestionsError(err))
+	case storage.ErrShortEmpty, storage.ErrURLEmpty, storage.ErrURLNotAbsolute, storage.ErrInvalidTemplate:
+		writeJSONError(w, http.StatusBadRequest, apiError{Error: cause.Error()})
+	case storage.ErrInvalidRemap:
+		// The wrapped message says which part of the remap is wrong
+		writeJSONError(w, http.StatusBadRequest, apiError{Error: err.Error()})
+	case storage.ErrRegexReadOnly, storage.ErrReadOnly:
+		writeJSONError(w, http.StatusMethodNotAllowed, apiError{Error: cause.Error()})
+	case storage.ErrNotSupported:
+		writeJSONError(w, http.StatusNotImplemented, apiError{Error: cause.Error()})
+	default:
+		if _, ok := cause.(*url.Error); ok {
+			writeJSONError(w, http.StatusBadRequest, apiError{Error: cause.Error()})
+			return
+		}
+
+		log.Printf("Error: %s", err)
+		writeJSONError(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+	}
+}
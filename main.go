@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/jessevdk/go-flags"
@@ -11,22 +16,81 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/thomasdesr/go-shorten/handlers"
 	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/multistorage"
 )
 
 var opts Options
 
+// shutdownTimeout is how long requests in flight get to finish when we're asked to stop
+const shutdownTimeout = 30 * time.Second
+
 func main() {
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true // Without a command we serve HTTP
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		// Load the config file before anything uses the options
+		if opts.Config != "" {
+			if err := loadConfigFile(parser, &opts, opts.Config); err != nil {
+				return err
+			}
+		}
+
+		if command == nil {
+			return nil
+		}
+		return command.Execute(args)
+	}
+
+	parser.AddCommand("export",
+		"Export links",
+		"Streams every link in the configured storage to a JSON Lines or CSV file",
+		&ExportCommand{},
+	)
+	parser.AddCommand("import",
+		"Import links",
+		"Saves every row of a JSON Lines or CSV file into the configured storage, reporting rows that were rejected",
+		&ImportCommand{},
+	)
+	parser.AddCommand("migrate",
+		"Migrate links between storages",
+		"Copies every link, along with its history and usage where both storages support them, from one storage to another. Safe to re-run.",
+		&MigrateCommand{},
+	)
+	parser.AddCommand("check-remaps",
+		"Check regex remaps",
+		"Validates a regex remap file and checks which remap each of a set of example shorts is sent to, failing if any don't match or go somewhere unexpected",
+		&CheckRemapsCommand{},
+	)
+
+	if _, err := parser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return
+		}
+		os.Exit(1)
+	}
+	if parser.Active != nil {
+		// A command ran instead
 		return
 	}
 
-	store, err := createStorageFromOption(&opts)
+	backend, err := createStorageFromOption(&opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := opts.cached(backend)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	log.Println("Storage successfully created")
 
+	go reloadOnHangup(store, backend)
+	if ms, ok := backend.(*multistorage.MultiStorage); ok && opts.Multistorage.SyncInterval > 0 {
+		log.Printf("Syncing stores every %s", opts.Multistorage.SyncInterval)
+		go ms.SyncEvery(context.Background(), opts.Multistorage.SyncInterval)
+	}
+
 	n := negroni.New(
 		negroni.NewRecovery(),
 		negroni.NewLogger(),
@@ -34,7 +98,7 @@ func main() {
 	)
 
 	r := httprouter.New()
-	r.Handler("GET", "/healthcheck", handlers.Healthcheck(store, "/healthcheck"))
+	r.Handler("GET", "/healthcheck", handlers.Healthcheck(backend, "/healthcheck")) // Skip the cache so it checks the storage
 
 	// Serve the index
 	indexPage, err := handlers.NewIndex("static/templates/index.tmpl")
@@ -49,16 +113,40 @@ func main() {
 	// Go Endpoints
 	r.Handler("GET", "/go", handlers.ServeGoDashboard())
 
-	// API handlers
-	r.Handler("POST", "/", handlers.SetShort(store)) // TODO(@thomas): move this to a stable API endpoint
-	if ss, ok := store.(storage.SearchableStorage); ok {
+	// API handlers. What's supported is up to the backend (for a multistorage, its children), the cache (if there is
+	// one) only handles loading and changing links so everything else goes straight to the backend.
+	r.Handler("POST", "/", handlers.SetShort(store, backend)) // Kept for existing form/curl clients, prefer /_api/v1/links
+	r.Handler("GET", "/_api/v1/links/:short", handlers.GetLink(store, backend))
+	r.Handler("PUT", "/_api/v1/links/:short", handlers.PutLink(store, backend))
+	r.Handler("POST", "/_api/v1/links", handlers.CreateLink(store, backend))
+	if ls, ok := storage.Supports[storage.ListableStorage](backend); ok {
+		r.Handler("GET", "/_api/v1/links", handlers.ListLinks(ls))
+	}
+	if hs, ok := storage.Supports[storage.HistoryStorage](backend); ok {
+		r.Handler("GET", "/_api/v1/links/:short/history", handlers.GetLinkHistory(hs))
+	}
+	if ss, ok := storage.Supports[storage.StatsStorage](backend); ok {
+		r.Handler("GET", "/_api/v1/links/:short/stats", handlers.GetLinkStats(ss))
+	}
+	if _, ok := storage.Supports[storage.RevertableStorage](backend); ok {
+		r.Handler("POST", "/_api/v1/links/:short/revert", handlers.RevertLink(store.(handlers.Reverter), backend))
+	}
+	if _, ok := storage.Supports[storage.DeletableStorage](backend); ok {
+		r.Handler("DELETE", "/*short", handlers.DeleteShort(store.(storage.DeletableStorage)))
+	}
+	if ss, ok := storage.Supports[storage.SearchableStorage](backend); ok {
 		r.Handler("GET", "/_api/v1/search", handlers.Search(ss))
 	}
-	if tns, ok := store.(storage.TopN); ok {
+	if tns, ok := storage.Supports[storage.TopN](backend); ok {
 		r.Handler("GET", "/_api/v1/top_n", handlers.TopN(tns))
 	}
 
-	n.UseHandler(r)
+	if len(opts.Identity.UserHeaders) > 0 {
+		log.Printf("Trusting %v headers for user identity", opts.Identity.UserHeaders)
+		n.UseHandler(handlers.WithIdentity(handlers.HeaderIdentity(opts.Identity.UserHeaders...), r))
+	} else {
+		n.UseHandler(r)
+	}
 
 	go func() {
 		log.Printf("Starting prometheus HTTP Listner on %s", net.JoinHostPort(opts.BindHost, "8081"))
@@ -68,9 +156,39 @@ func main() {
 		}
 	}()
 
-	log.Printf("Starting HTTP Listener on: %s", net.JoinHostPort(opts.BindHost, opts.BindPort))
-	err = http.ListenAndServe(net.JoinHostPort(opts.BindHost, opts.BindPort), n)
-	if err != nil {
+	server := &http.Server{Addr: net.JoinHostPort(opts.BindHost, opts.BindPort), Handler: n}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		shutdownOnSignal(server, store)
+	}()
+
+	log.Printf("Starting HTTP Listener on: %s", server.Addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-shutdown // ListenAndServe returns as soon as shutting down starts
+}
+
+// shutdownOnSignal stops server on SIGINT or SIGTERM once the requests it's serving finish, then closes store so it
+// can finish anything it has buffered
+func shutdownOnSignal(server *http.Server, store storage.Storage) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	signal.Stop(stop) // A second signal kills us straight away
+
+	log.Println("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down HTTP listener: %s", err)
+	}
+
+	if cs, ok := store.(storage.ClosableStorage); ok {
+		if err := cs.Close(); err != nil {
+			log.Printf("Failed to close storage: %s", err)
+		}
+	}
 }
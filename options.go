@@ -1,24 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/google/shlex"
 	flags "github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
 	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/cache"
 	"github.com/thomasdesr/go-shorten/storage/multistorage"
 )
 
 type Options struct {
+	Config string `long:"config" env:"CONFIG" no-ini:"true"`
+
 	BindHost string `long:"host"    default:"0.0.0.0"   env:"HOST"`
 	BindPort string `long:"port"    default:"8080"      env:"PORT"`
 
 	StorageType string `long:"storage-type" default:"Inmem" ini-name:"storage_type" env:"STORAGE_TYPE"`
 	// StorageConfig string `long:"storage-config" ini-name:"storage_config"`
 
+	Identity struct {
+		UserHeaders []string `long:"user-header" env:"USER_HEADER" env-delim:","`
+	} `group:"Identity Options"`
+
 	// S3 Config options
 	S3 struct {
 		BucketName string `long:"s3-bucket" default:"go-shorten"    env:"S3_BUCKET"`
@@ -35,25 +44,101 @@ type Options struct {
 	} `group:"Filesystem Storage Options"`
 
 	Regex struct {
-		Remaps map[string]string `long:"regex-remap" env:"REGEX_REMAP"`
+		Remaps   map[string]string `long:"regex-remap" env:"REGEX_REMAP"`
+		File     string            `long:"regex-file" env:"REGEX_FILE"`
+		Anchored bool              `long:"regex-anchored" env:"REGEX_ANCHORED"`
 	} `group:"Regex Storage Options"`
 
 	Multistorage struct {
-		StorageArgs []string `long:"multi-sub-args" env:"MULTI_SUB_ARGS"`
+		StorageArgs  []string      `long:"multi-sub-args" env:"MULTI_SUB_ARGS"`
+		Loader       string        `long:"multi-loader" default:"first" choice:"first" choice:"first-parallel" choice:"compare-all" choice:"quorum" env:"MULTI_LOADER"`
+		Quorum       int           `long:"multi-quorum" env:"MULTI_QUORUM"` // Defaults to a majority of the children
+		Saver        string        `long:"multi-saver" default:"all" choice:"all" choice:"once" env:"MULTI_SAVER"`
+		ReadRepair   bool          `long:"multi-read-repair" env:"MULTI_READ_REPAIR"`
+		SyncInterval time.Duration `long:"multi-sync-interval" env:"MULTI_SYNC_INTERVAL"`
+
+		// ReadOnly is set on a child to stop its multistorage saving links into it, it's an error anywhere else
+		ReadOnly bool `long:"multi-read-only"`
+
+		// Children are the structured children from a YAML config file's multi-children, after any --multi-sub-args
+		Children []*Options `no-flag:"true"`
 	} `group:"Multi Storage Options"`
 
 	Postgres struct {
-		ConnectString string `long:"postgres-connect-string" env:"POSTGRES_CONNECT_STRING"`
+		ConnectString    string        `long:"postgres-connect-string" env:"POSTGRES_CONNECT_STRING"`
+		HitQueueSize     int           `long:"postgres-hit-queue-size" default:"10000" env:"POSTGRES_HIT_QUEUE_SIZE"`
+		HitBatchSize     int           `long:"postgres-hit-batch-size" default:"500" env:"POSTGRES_HIT_BATCH_SIZE"`
+		HitFlushInterval time.Duration `long:"postgres-hit-flush-interval" default:"1s" env:"POSTGRES_HIT_FLUSH_INTERVAL"`
 	} `group:"Postgres"`
+
+	// Read-through cache in front of the storage, used when serving
+	Cache struct {
+		Size        int           `long:"cache-size" env:"CACHE_SIZE"`
+		TTL         time.Duration `long:"cache-ttl" default:"1m" env:"CACHE_TTL"`
+		NotFoundTTL time.Duration `long:"cache-not-found-ttl" default:"10s" env:"CACHE_NOT_FOUND_TTL"`
+	} `group:"Cache Options"`
+
+	// Fuzzy matching options for "did you mean" suggestions, used by every storage type that supports them
+	Fuzzy struct {
+		MaxDistance    int `long:"fuzzy-max-distance" default:"4" env:"FUZZY_MAX_DISTANCE"`
+		MinPhonetic    int `long:"fuzzy-min-phonetic" default:"3" env:"FUZZY_MIN_PHONETIC"`
+		MaxSuggestions int `long:"fuzzy-max-suggestions" default:"5" env:"FUZZY_MAX_SUGGESTIONS"`
+	} `group:"Fuzzy Matching Options"`
 }
 
+// fuzzyMatcher returns the storage.FuzzyMatcher described by the options, or nil if --fuzzy-max-distance or
+// --fuzzy-max-suggestions is 0
+func (opts *Options) fuzzyMatcher() *storage.FuzzyMatcher {
+	if opts.Fuzzy.MaxDistance <= 0 || opts.Fuzzy.MaxSuggestions <= 0 {
+		return nil
+	}
+
+	return &storage.FuzzyMatcher{
+		MaxDistance:    opts.Fuzzy.MaxDistance,
+		MinPhonetic:    opts.Fuzzy.MinPhonetic,
+		MaxSuggestions: opts.Fuzzy.MaxSuggestions,
+	}
+}
+
+// cached puts a cache in front of store if --cache-size is set
+func (opts *Options) cached(store storage.NamedStorage) (storage.NamedStorage, error) {
+	if opts.Cache.Size <= 0 {
+		return store, nil
+	}
+
+	log.Printf("Caching up to %d links for %s (%s when not found)", opts.Cache.Size, opts.Cache.TTL, opts.Cache.NotFoundTTL)
+	return cache.New(store,
+		cache.Size(opts.Cache.Size),
+		cache.TTL(opts.Cache.TTL),
+		cache.NotFoundTTL(opts.Cache.NotFoundTTL),
+	)
+}
+
+// errReadOnlyOutsideMultistorage is returned for --multi-read-only on a storage that isn't a multistorage's child
+var errReadOnlyOutsideMultistorage = errors.New("--multi-read-only can only be set on a multistorage's children")
+
 // createStorageFromOption takes an Option struct and based on the StorageType field constructs a storage.Storage and returns it.
 func createStorageFromOption(opts *Options) (storage.NamedStorage, error) {
+	if opts.Multistorage.ReadOnly {
+		return nil, errReadOnlyOutsideMultistorage
+	}
+
+	return createStorage(opts)
+}
+
+// createStorage is createStorageFromOption for a multistorage's children, which are allowed to be read-only
+func createStorage(opts *Options) (storage.NamedStorage, error) {
 	switch strings.ToLower(opts.StorageType) {
 	case "inmem":
 		log.Printf("Setting up an Inmem Storage layer with short code length of '%d'", opts.Inmem.RandLength)
 
-		return storage.NewInmem(opts.Inmem.RandLength)
+		s, err := storage.NewInmem(opts.Inmem.RandLength)
+		if err != nil {
+			return nil, err
+		}
+		s.Fuzzy = opts.fuzzyMatcher()
+
+		return s, nil
 	case "s3":
 		log.Println("Setting up an S3 Storage layer")
 
@@ -61,21 +146,56 @@ func createStorageFromOption(opts *Options) (storage.NamedStorage, error) {
 			log.Fatalf("BucketName has be something (currently empty)")
 		}
 
-		return storage.NewS3(nil, opts.S3.BucketName)
+		s, err := storage.NewS3(nil, opts.S3.BucketName)
+		if err != nil {
+			return nil, err
+		}
+		s.Fuzzy = opts.fuzzyMatcher()
+
+		return s, nil
 	case "filesystem":
 		log.Printf("Setting up a Filesystem storage layer with root: %v", opts.Filesystem.RootPath)
 
-		return storage.NewFilesystem(opts.Filesystem.RootPath)
+		s, err := storage.NewFilesystem(opts.Filesystem.RootPath)
+		if err != nil {
+			return nil, err
+		}
+		s.Fuzzy = opts.fuzzyMatcher()
+
+		return s, nil
 	case "regex":
-		log.Printf("Setting up a Regex storage with %v remaps", opts.Regex.Remaps)
+		r, err := createRegexStorage(opts)
+		if err != nil {
+			return nil, err
+		}
 
-		return storage.NewRegexFromList(opts.Regex.Remaps)
+		for _, shadowed := range r.Shadowed() {
+			log.Printf("Warning: regex remap %q looks shadowed by the earlier remap %q, give it a higher priority if it should win", shadowed.Remap.Pattern, shadowed.By.Pattern)
+		}
+
+		return r, nil
 	case "postgres":
 		log.Printf("Setting up a Postgres backed storage layer")
 
-		return storage.NewPostgres(opts.Postgres.ConnectString)
+		s, err := storage.NewPostgres(opts.Postgres.ConnectString)
+		if err != nil {
+			return nil, err
+		}
+		s.Fuzzy = opts.fuzzyMatcher()
+		s.Hits = storage.HitRecording{
+			QueueSize:     opts.Postgres.HitQueueSize,
+			BatchSize:     opts.Postgres.HitBatchSize,
+			FlushInterval: opts.Postgres.HitFlushInterval,
+		}
+
+		return s, nil
 	case "multistorage":
-		storageCount := len(opts.Multistorage.StorageArgs)
+		children, err := opts.multistorageChildren()
+		if err != nil {
+			return nil, err
+		}
+
+		storageCount := len(children)
 		if storageCount == 0 {
 			log.Fatal("Multistorage requires at least one child storage")
 		}
@@ -83,30 +203,156 @@ func createStorageFromOption(opts *Options) (storage.NamedStorage, error) {
 
 		storageNames := make([]string, 0, storageCount)
 		storages := make([]storage.NamedStorage, 0, storageCount)
-		for i, rawArgs := range opts.Multistorage.StorageArgs {
-			subArgs, err := shlex.Split(rawArgs)
-			if err != nil {
-				return nil, errors.Wrapf(err, "failed to split arguments for argument #%d", i)
-			}
-
-			var subOpt Options
-			_, err = flags.ParseArgs(&subOpt, subArgs)
+		var readOnly []int
+		for i, child := range children {
+			store, err := createStorage(child)
 			if err != nil {
-				return nil, errors.Wrapf(err, "failed to cli parse sub argument #%d", i)
+				return nil, errors.Wrapf(err, "failed to create storage #%d", i)
 			}
 
-			store, err := createStorageFromOption(&subOpt)
-			if err != nil {
-				return nil, errors.Wrapf(err, "failed to create storage #%d from args", i)
+			name := child.StorageType
+			if child.Multistorage.ReadOnly {
+				readOnly = append(readOnly, i)
+				name += " (read-only)"
 			}
 
-			storageNames = append(storageNames, subOpt.StorageType)
+			storageNames = append(storageNames, name)
 			storages = append(storages, store)
 		}
 
-		log.Printf("Multilayer Storage created with children: %v", strings.Join(storageNames, ", "))
-		return multistorage.Simple(storages...)
+		msOpts, err := opts.multistorageOptions(storageCount, readOnly)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Multilayer Storage created with children: %v, loading with %s and saving to %s", strings.Join(storageNames, ", "), opts.Multistorage.Loader, opts.Multistorage.Saver)
+		return multistorage.New(storages, msOpts...)
 	default:
 		return nil, fmt.Errorf("Unsupported storage-type: '%s'", opts.StorageType)
 	}
 }
+
+// multistorageChildren returns the options of each of a multistorage's children, the ones from --multi-sub-args first
+func (opts *Options) multistorageChildren() ([]*Options, error) {
+	children := make([]*Options, 0, len(opts.Multistorage.StorageArgs)+len(opts.Multistorage.Children))
+	for i, rawArgs := range opts.Multistorage.StorageArgs {
+		child, err := parseStorageArgs(rawArgs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse storage #%d", i)
+		}
+
+		children = append(children, child)
+	}
+
+	return append(children, opts.Multistorage.Children...), nil
+}
+
+// multistorageOptions turns --multi-loader, --multi-saver and friends into the options for a multistorage with
+// storageCount children, the ones at readOnly being read-only
+func (opts *Options) multistorageOptions(storageCount int, readOnly []int) ([]multistorage.MultiStorageOption, error) {
+	var msOpts []multistorage.MultiStorageOption
+
+	switch opts.Multistorage.Loader {
+	case "first":
+		msOpts = append(msOpts, multistorage.LoadFirst())
+	case "first-parallel":
+		msOpts = append(msOpts, multistorage.LoadFirstParallel())
+	case "compare-all":
+		msOpts = append(msOpts, multistorage.LoadCompareAllResults())
+	case "quorum":
+		msOpts = append(msOpts, multistorage.LoadQuorum(opts.multistorageQuorum(storageCount)))
+	default:
+		return nil, fmt.Errorf("Unsupported multi-loader: '%s'", opts.Multistorage.Loader)
+	}
+
+	switch opts.Multistorage.Saver {
+	case "all":
+		msOpts = append(msOpts, multistorage.SaveToAll())
+	case "once":
+		msOpts = append(msOpts, multistorage.SaveOnlyOnce())
+	default:
+		return nil, fmt.Errorf("Unsupported multi-saver: '%s'", opts.Multistorage.Saver)
+	}
+
+	if opts.Multistorage.ReadRepair {
+		msOpts = append(msOpts, multistorage.ReadRepair())
+	}
+	if len(readOnly) > 0 {
+		msOpts = append(msOpts, multistorage.ReadOnly(readOnly...))
+	}
+
+	return msOpts, nil
+}
+
+// multistorageQuorum is --multi-quorum, or a majority of storageCount children if it isn't set
+func (opts *Options) multistorageQuorum(storageCount int) int {
+	if opts.Multistorage.Quorum == 0 {
+		return storageCount/2 + 1
+	}
+
+	return opts.Multistorage.Quorum
+}
+
+// parseStorageArgs parses a shell quoted string of storage options, e.g. "--storage-type filesystem --root-path
+// ./links"
+func parseStorageArgs(rawArgs string) (*Options, error) {
+	args, err := shlex.Split(rawArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to split arguments")
+	}
+
+	var subOpt Options
+	if _, err := flags.ParseArgs(&subOpt, args); err != nil {
+		return nil, errors.Wrap(err, "failed to cli parse arguments")
+	}
+
+	return &subOpt, nil
+}
+
+// createStorageFromArgs constructs the storage.Storage described by a shell quoted string of storage options. It also
+// returns the storage type for logging.
+func createStorageFromArgs(rawArgs string) (storage.NamedStorage, string, error) {
+	subOpt, err := parseStorageArgs(rawArgs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	store, err := createStorageFromOption(subOpt)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create storage from args")
+	}
+
+	return store, subOpt.StorageType, nil
+}
+
+// createRegexStorage builds a Regex storage from --regex-remap and --regex-file, adding any --regex-remap remaps to
+// the file
+func createRegexStorage(opts *Options) (*storage.Regex, error) {
+	if opts.Regex.File == "" {
+		log.Printf("Setting up a Regex storage with %v remaps", opts.Regex.Remaps)
+
+		r, err := storage.NewRegexFromList(opts.Regex.Remaps)
+		if err != nil {
+			return nil, err
+		}
+		r.Anchored = opts.Regex.Anchored
+
+		return r, nil
+	}
+
+	log.Printf("Setting up a Regex storage backed by %s, adding %v remaps", opts.Regex.File, opts.Regex.Remaps)
+
+	r, err := storage.NewRegexFromFile(opts.Regex.File)
+	if err != nil {
+		return nil, err
+	}
+	r.Anchored = opts.Regex.Anchored
+
+	for regex, replacement := range opts.Regex.Remaps {
+		if err := r.SaveName(context.Background(), regex, replacement); err != nil {
+			return nil, errors.Wrapf(err, "failed to add remap %q", regex)
+		}
+	}
+
+	return r, nil
+}
@@ -41,21 +41,6 @@
     }
   }
 
-  /**
-   * Helper for serializing form data.
-   * @TODO(jengler) 2016-02-22: Replace with JSON
-   *
-   * @param  {Object} data Object containing key/value pairs to serialize.
-   * @return {string}      The urlencoded query string.
-   */
-  function serialize(data) {
-    var serialized = [];
-    for (var param in data) {
-      serialized.push(encodeURIComponent(param) + '=' + encodeURIComponent(data[param]));
-    }
-    return serialized.join('&').replace(/%20/g, '+');
-  };
-
   /**
    * Form submit handler. Makes the world all ajaxy.
    *
@@ -70,30 +55,29 @@
     var code = document.getElementById("code").value.trim();
     var url = document.getElementById("url").value.trim();
 
-    var serialized = serialize({
-      code: code,
+    createShort({
+      short: code,
       url: url
     });
-
-    createShort(serialized);
   };
 
   /**
-   * Create a short URL for the provided form data.
+   * Create a short URL for the provided link.
    *
-   * @param  {FormData} formData Must at least have 'url'. 'code' is optional
+   * @param  {Object} link Must at least have 'url'. 'short' is optional
    * @return {none}
    */
-  function createShort(formData) {
+  function createShort(link) {
     var xhr = new XMLHttpRequest();
 
     xhr.addEventListener("load", handleCreateSuccess);
     xhr.addEventListener("error", handleCreateError);
 
-    xhr.open("POST", "/");
-    xhr.setRequestHeader("Content-type", "application/x-www-form-urlencoded");
+    xhr.open("POST", "/_api/v1/links");
+    xhr.setRequestHeader("Content-type", "application/json");
+    xhr.setRequestHeader("Accept", "application/json");
 
-    xhr.send(formData);
+    xhr.send(JSON.stringify(link));
   }
 
   /**
@@ -102,9 +86,10 @@
    * @return {none}
    */
   function handleCreateSuccess(event) {
-    if (event.target.status === 200) {
+    // 201 for a new link, 200 when an existing one was changed
+    if (event.target.status === 201 || event.target.status === 200) {
       console.log('Success');
-      showSuccessLink(event.target.response.trim());
+      showSuccessLink(JSON.parse(event.target.response).short);
     } else {
       handleCreateError(event);
     }
@@ -117,8 +102,14 @@
    */
   function handleCreateError(event) {
     console.log("error", event.target.status, event.target.response);
-    // @TODO(jengler) 2016-2-22: Don't expose raw response to user.
-    alert("Error: " + event.target.response);
+
+    var message = event.target.response;
+    try {
+      message = JSON.parse(message).error;
+    } catch (e) {
+      // Not a JSON API error, show the raw response
+    }
+    alert("Error: " + message);
   }
 
   /**
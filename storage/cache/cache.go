@@ -0,0 +1,253 @@
+// Package cache puts an in-memory cache of Load results in front of a storage
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+var lookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "storage_cache",
+		Name:      "lookups_total",
+		Help:      "A counter for cache lookups, by whether they found a link (hit), found that a link doesn't exist (not_found_hit) or had to ask the storage (miss)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(lookups)
+}
+
+// Cache is a storage.NamedStorage that remembers what the storage it wraps returned from Load, for TTL when a short
+// was found and NotFoundTTL when it wasn't. It holds at most Size shorts, dropping the least recently used ones first.
+//
+// Anything changed through the Cache empties it, since parameterized links and regexes mean a single save can change
+// what any number of shorts load. Changes made any other way show up once the cached results expire. Visits served
+// from the cache are still counted by storages that implement storage.VisitStorage.
+//
+// The Cache only stands in for Load and the methods that change links. Everything else, like History or Search, should
+// be asked of the storage directly, and the storage (not the Cache) says which of the changes it supports: the Cache
+// returns storage.ErrNotSupported for the ones it doesn't.
+type Cache struct {
+	store       storage.NamedStorage
+	size        int
+	ttl         time.Duration
+	notFoundTTL time.Duration
+
+	entries    map[string]*list.Element
+	lru        *list.List // Most recently used at the front
+	generation uint64     // Bumped every time the cache is emptied
+	mu         sync.Mutex
+}
+
+type entry struct {
+	short   string
+	long    string
+	err     error
+	expires time.Time
+}
+
+var (
+	DefaultSize        = 10000
+	DefaultTTL         = time.Minute
+	DefaultNotFoundTTL = 10 * time.Second
+)
+
+var ErrInvalidSize = errors.New("cache size must be positive")
+
+func New(store storage.NamedStorage, opts ...CacheOption) (*Cache, error) {
+	c := &Cache{
+		store:       store,
+		size:        DefaultSize,
+		ttl:         DefaultTTL,
+		notFoundTTL: DefaultNotFoundTTL,
+
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.size <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	return c, nil
+}
+
+func (c *Cache) String() string {
+	return fmt.Sprintf("cache(%v)", c.store)
+}
+
+// Load returns the cached result for short if there's one that hasn't expired, otherwise it loads it from the storage
+// and caches it
+func (c *Cache) Load(ctx context.Context, short string) (string, error) {
+	c.mu.Lock()
+	if e, ok := c.get(short); ok {
+		c.mu.Unlock()
+
+		if e.err != nil {
+			lookups.WithLabelValues("not_found_hit").Inc()
+		} else {
+			lookups.WithLabelValues("hit").Inc()
+			c.recordVisit(ctx, short)
+		}
+		return e.long, e.err
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	lookups.WithLabelValues("miss").Inc()
+	long, err := c.store.Load(ctx, short)
+
+	var ttl time.Duration
+	switch errors.Cause(err) {
+	case nil:
+		ttl = c.ttl
+	case storage.ErrShortNotSet, storage.ErrFuzzyMatchFound:
+		ttl = c.notFoundTTL
+	}
+
+	if ttl > 0 {
+		c.mu.Lock()
+		// If the cache was emptied while we were loading, our result might be from before the change
+		if generation == c.generation {
+			c.add(&entry{short: short, long: long, err: err, expires: time.Now().Add(ttl)})
+		}
+		c.mu.Unlock()
+	}
+
+	return long, err
+}
+
+// recordVisit counts a visit to short that was answered from the cache in the storage, since the storage never saw it
+func (c *Cache) recordVisit(ctx context.Context, short string) {
+	vs, ok := c.store.(storage.VisitStorage)
+	if !ok {
+		return
+	}
+
+	if err := vs.RecordVisit(ctx, short); err != nil && errors.Cause(err) != storage.ErrShortNotSet {
+		log.Printf("Error recording visit to %q: %s", short, err)
+	}
+}
+
+// get returns the unexpired entry for short, callers must hold c.mu
+func (c *Cache) get(short string) (*entry, bool) {
+	elem, ok := c.entries[short]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, short)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return e, true
+}
+
+// add caches e, evicting the least recently used entry if the cache is full. Callers must hold c.mu.
+func (c *Cache) add(e *entry) {
+	if elem, ok := c.entries[e.short]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[e.short] = c.lru.PushFront(e)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).short)
+	}
+}
+
+// Purge empties the cache
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.generation++
+}
+
+// Len returns how many shorts are cached, including ones that have expired but haven't been dropped yet
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache) SaveName(ctx context.Context, short string, long string) error {
+	defer c.Purge()
+	return c.store.SaveName(ctx, short, long)
+}
+
+func (c *Cache) Save(ctx context.Context, url string) (string, error) {
+	us, ok := c.store.(storage.UnnamedStorage)
+	if !ok {
+		return "", storage.ErrNotSupported
+	}
+
+	defer c.Purge()
+	return us.Save(ctx, url)
+}
+
+func (c *Cache) Delete(ctx context.Context, short string) error {
+	ds, ok := c.store.(storage.DeletableStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	defer c.Purge()
+	return ds.Delete(ctx, short)
+}
+
+func (c *Cache) Revert(ctx context.Context, short string, revision string) error {
+	rs, ok := c.store.(storage.RevertableStorage)
+	if !ok {
+		return storage.ErrNotSupported
+	}
+
+	defer c.Purge()
+	return rs.Revert(ctx, short, revision)
+}
+
+// Reload reloads the storage if it supports it, emptying the cache since anything could have changed
+func (c *Cache) Reload(ctx context.Context) error {
+	rs, ok := c.store.(storage.ReloadableStorage)
+	if !ok {
+		return nil
+	}
+
+	defer c.Purge()
+	return rs.Reload(ctx)
+}
+
+func (c *Cache) Close() error {
+	cs, ok := c.store.(storage.ClosableStorage)
+	if !ok {
+		return nil
+	}
+
+	return cs.Close()
+}
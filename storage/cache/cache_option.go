@@ -0,0 +1,30 @@
+package cache
+
+import "time"
+
+// CacheOption configures how much a Cache holds and for how long
+type CacheOption func(*Cache) error
+
+// Size sets how many shorts the Cache holds before it starts dropping the least recently used ones
+func Size(size int) CacheOption {
+	return func(c *Cache) error {
+		c.size = size
+		return nil
+	}
+}
+
+// TTL sets how long a short that was found is cached for
+func TTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) error {
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// NotFoundTTL sets how long a short that wasn't found is cached for, 0 stops not found results being cached
+func NotFoundTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) error {
+		c.notFoundTTL = ttl
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package cache_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/cache"
+)
+
+// countingStorage counts how many times Load reaches the storage
+type countingStorage struct {
+	*storage.Inmem
+	loads int32
+}
+
+func (s *countingStorage) Load(ctx context.Context, short string) (string, error) {
+	atomic.AddInt32(&s.loads, 1)
+	return s.Inmem.Load(ctx, short)
+}
+
+func setupCache(t *testing.T, links map[string]string, opts ...cache.CacheOption) (*cache.Cache, *countingStorage) {
+	inmem, err := storage.NewInmemFromMap(8, links)
+	require.Nil(t, err)
+
+	store := &countingStorage{Inmem: inmem}
+	c, err := cache.New(store, opts...)
+	require.Nil(t, err)
+
+	return c, store
+}
+
+func TestCacheLoad(t *testing.T) {
+	c, store := setupCache(t, map[string]string{"a": "http://A"})
+
+	for i := 0; i < 3; i++ {
+		long, err := c.Load(context.Background(), "a")
+		assert.Nil(t, err)
+		assert.Equal(t, "http://A", long)
+	}
+	assert.EqualValues(t, 1, store.loads, "only the first load should reach the storage")
+
+	for i := 0; i < 3; i++ {
+		_, err := c.Load(context.Background(), "missing")
+		assert.Equal(t, storage.ErrShortNotSet, errors.Cause(err))
+	}
+	assert.EqualValues(t, 2, store.loads, "not found results should be cached too")
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, store := setupCache(t, map[string]string{"a": "http://A"},
+		cache.TTL(10*time.Millisecond),
+		cache.NotFoundTTL(0),
+	)
+
+	c.Load(context.Background(), "a")
+	c.Load(context.Background(), "a")
+	assert.EqualValues(t, 1, store.loads)
+
+	time.Sleep(20 * time.Millisecond)
+	c.Load(context.Background(), "a")
+	assert.EqualValues(t, 2, store.loads, "expired links should be loaded again")
+
+	c.Load(context.Background(), "missing")
+	c.Load(context.Background(), "missing")
+	assert.EqualValues(t, 4, store.loads, "not found results shouldn't be cached with a NotFoundTTL of 0")
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, store := setupCache(t, map[string]string{"a": "http://A", "b": "http://B", "c": "http://C"}, cache.Size(2))
+
+	c.Load(context.Background(), "a")
+	c.Load(context.Background(), "b")
+	c.Load(context.Background(), "a") // a is now used more recently than b
+	c.Load(context.Background(), "c") // so b is the one that makes room for c
+	assert.Equal(t, 2, c.Len())
+	assert.EqualValues(t, 3, store.loads)
+
+	c.Load(context.Background(), "a")
+	c.Load(context.Background(), "c")
+	assert.EqualValues(t, 3, store.loads)
+
+	c.Load(context.Background(), "b")
+	assert.EqualValues(t, 4, store.loads)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c, _ := setupCache(t, map[string]string{"a": "http://A"})
+
+	c.Load(context.Background(), "a")
+	c.Load(context.Background(), "pr/1")
+
+	require.Nil(t, c.SaveName(context.Background(), "a", "http://B"))
+	long, err := c.Load(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://B", long, "saving should replace the cached link")
+
+	// Saves can change shorts other than the one saved
+	require.Nil(t, c.SaveName(context.Background(), "pr/{id}", "https://github.com/pulls/{id}"))
+	long, err = c.Load(context.Background(), "pr/1")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://github.com/pulls/1", long, "saving should drop cached not found results")
+
+	require.Nil(t, c.Delete(context.Background(), "a"))
+	_, err = c.Load(context.Background(), "a")
+	assert.Equal(t, storage.ErrShortNotSet, errors.Cause(err), "deleting should drop the cached link")
+}
+
+func TestCacheVisits(t *testing.T) {
+	c, store := setupCache(t, map[string]string{"a": "http://A"})
+
+	for i := 0; i < 3; i++ {
+		c.Load(context.Background(), "a")
+	}
+	c.Load(context.Background(), "missing")
+	assert.EqualValues(t, 2, store.loads)
+
+	top, err := store.TopNForPeriod(context.Background(), 10, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []storage.TopNResult{{Link: "a", HitCount: 3}}, top, "visits answered from the cache should still be counted")
+}
+
+func TestCacheUnsupported(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{"a": "http://A"})
+	require.Nil(t, err)
+
+	c, err := cache.New(regex)
+	require.Nil(t, err)
+
+	// Only what the cache stands in for is on it, everything else has to be asked of the storage
+	var store storage.Storage = c
+	_, ok := store.(storage.MetadataStorage)
+	assert.False(t, ok)
+	_, ok = store.(storage.SearchableStorage)
+	assert.False(t, ok)
+
+	err = c.Revert(context.Background(), "a", "1")
+	assert.Equal(t, storage.ErrNotSupported, err)
+
+	_, err = cache.New(regex, cache.Size(0))
+	assert.Equal(t, cache.ErrInvalidSize, err)
+}
@@ -2,22 +2,38 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 type Filesystem struct {
-	Root string
-	mu   sync.RWMutex
+	Root       string
+	RandLength int
+	// Fuzzy suggests a similar short when one isn't found, nil disables suggestions
+	Fuzzy *FuzzyMatcher
+
+	mu sync.RWMutex
+	// index saves reading every file when searching
+	index *linkIndex
 }
 
+// filesystemIndexMaxAge is how long Filesystem trusts its index to have any links written by other processes
+const filesystemIndexMaxAge = time.Minute
+
 func NewFilesystem(root string) (*Filesystem, error) {
 	s := &Filesystem{
-		Root: root,
+		Root:       root,
+		RandLength: DefaultRandLength,
 	}
+	s.index = newLinkIndex(s.List, filesystemIndexMaxAge)
+
 	return s, os.MkdirAll(s.Root, 0744)
 }
 
@@ -36,24 +52,110 @@ func FlattenPath(path string, separator string) string {
 }
 
 func (s *Filesystem) SaveName(ctx context.Context, rawShort, url string) error {
-	short, err := sanitizeShort(rawShort)
+	short, url, err := validateLink(rawShort, url)
 	if err != nil {
 		return err
 	}
-	if _, err := validateURL(url); err != nil {
+
+	file := FlattenPath(CleanPath(short), "_")
+
+	s.mu.Lock()
+	err = ioutil.WriteFile(filepath.Join(s.Root, file), []byte(url), 0744)
+	if err == nil {
+		err = s.saveMetadata(ctx, file)
+	}
+	s.mu.Unlock()
+	if err != nil {
 		return err
 	}
 
-	short = FlattenPath(CleanPath(short), "_")
+	// The index fills itself with List, which takes s.mu, so only update it once we've let go
+	s.index.set(short, url)
+	return nil
+}
+
+func (s *Filesystem) Save(ctx context.Context, url string) (string, error) {
+	if _, err := validateURL(url); err != nil {
+		return "", err
+	}
 
 	s.mu.Lock()
-	err = ioutil.WriteFile(filepath.Join(s.Root, short), []byte(url), 0744)
+	short, err := GenerateShort(s.RandLength, func(short string) (bool, error) {
+		// O_EXCL makes the existence check and the creation a single step
+		f, err := os.OpenFile(filepath.Join(s.Root, FlattenPath(CleanPath(short), "_")), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0744)
+		if os.IsExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if _, err := f.WriteString(url); err != nil {
+			f.Close()
+			return false, err
+		}
+		if err := f.Close(); err != nil {
+			return false, err
+		}
+
+		return true, s.saveMetadata(ctx, FlattenPath(CleanPath(short), "_"))
+	})
 	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	s.index.set(short, url)
+	return short, nil
+}
 
-	return err
+// GeneratedLength returns how many characters the shorts Save generates have
+func (s *Filesystem) GeneratedLength() int {
+	return RandLength(s.RandLength)
 }
 
 func (s *Filesystem) Load(ctx context.Context, rawShort string) (string, error) {
+	url, err := s.loadExact(ctx, rawShort)
+	if err == ErrShortNotSet {
+		url, err = resolveTemplate(ctx, rawShort, s.loadExact)
+	}
+	if err == ErrShortNotSet {
+		return fuzzyNotFound(ctx, s.Fuzzy, rawShort, s.shorts, s.loadExact)
+	}
+
+	return url, err
+}
+
+// shorts returns every short s has, without reading their URLs
+func (s *Filesystem) shorts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	var shorts []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+			// Not a short, e.g. a metadata file
+			continue
+		}
+
+		shorts = append(shorts, strings.TrimPrefix(entry.Name(), "_"))
+	}
+
+	return shorts, nil
+}
+
+// LoadExact is Load without resolving parameterized links
+func (s *Filesystem) LoadExact(ctx context.Context, rawShort string) (string, error) {
+	return s.loadExact(ctx, rawShort)
+}
+
+// loadExact looks up a single short without trying to resolve parameterized links
+func (s *Filesystem) loadExact(ctx context.Context, rawShort string) (string, error) {
 	short, err := sanitizeShort(rawShort)
 	if err != nil {
 		return "", err
@@ -71,3 +173,136 @@ func (s *Filesystem) Load(ctx context.Context, rawShort string) (string, error)
 
 	return string(urlBytes), err
 }
+
+// CanonicalShort returns the lowercased short, without the characters that are ignored in shorts
+func (s *Filesystem) CanonicalShort(rawShort string) (string, error) {
+	return storedShort(rawShort)
+}
+
+func (s *Filesystem) Delete(ctx context.Context, rawShort string) error {
+	rawShort, _, err := templateShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	file := FlattenPath(CleanPath(short), "_")
+
+	s.mu.Lock()
+	err = os.Remove(filepath.Join(s.Root, file))
+	if err == nil {
+		if err = os.Remove(metadataPath(s.Root, file)); os.IsNotExist(err) {
+			err = nil
+		}
+	} else if os.IsNotExist(err) {
+		err = ErrShortNotSet
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.index.delete(short)
+	return nil
+}
+
+// metadataPath is where the metadata for a flattened short lives. Sanitized shorts never contain a '.' so this can't
+// collide with another short.
+func metadataPath(root, short string) string {
+	return filepath.Join(root, short+".json")
+}
+
+// saveMetadata updates the metadata of a flattened short, callers must hold s.mu
+func (s *Filesystem) saveMetadata(ctx context.Context, short string) error {
+	var md LinkMetadata
+	if b, err := ioutil.ReadFile(metadataPath(s.Root, short)); err == nil {
+		if err := json.Unmarshal(b, &md); err != nil {
+			return errors.Wrap(err, "failed to parse existing metadata")
+		}
+	}
+
+	b, err := json.Marshal(updateMetadata(md, UserFromContext(ctx)))
+	if err != nil {
+		return errors.Wrap(err, "failed to format metadata")
+	}
+
+	return ioutil.WriteFile(metadataPath(s.Root, short), b, 0744)
+}
+
+func (s *Filesystem) Metadata(ctx context.Context, rawShort string) (LinkMetadata, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	short = FlattenPath(CleanPath(short), "_")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := os.Stat(filepath.Join(s.Root, short)); os.IsNotExist(err) {
+		return LinkMetadata{}, ErrShortNotSet
+	}
+
+	var md LinkMetadata
+	b, err := ioutil.ReadFile(metadataPath(s.Root, short))
+	if os.IsNotExist(err) {
+		// Saved before we kept track of metadata
+		return md, nil
+	}
+	if err != nil {
+		return md, err
+	}
+
+	return md, json.Unmarshal(b, &md)
+}
+
+func (s *Filesystem) List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// ReadDir sorts by filename, and every short is stored as "_<short>" so that is also sorted by short
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var results []ListResult
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), ".") {
+			// Not a short, e.g. a metadata file
+			continue
+		}
+
+		short := strings.TrimPrefix(entry.Name(), "_")
+		if short <= cursor {
+			continue
+		}
+
+		urlBytes, err := ioutil.ReadFile(filepath.Join(s.Root, entry.Name()))
+		if err != nil {
+			return nil, "", err
+		}
+
+		results = append(results, ListResult{
+			Link: short,
+			URL:  string(urlBytes),
+		})
+
+		if limit > 0 && len(results) > limit {
+			break
+		}
+	}
+
+	results, next := pageListResults(results, limit)
+	return results, next, nil
+}
+
+// Search finds links whose short or URL is similar to searchTerm, most relevant first
+func (s *Filesystem) Search(ctx context.Context, searchTerm string) ([]SearchResult, error) {
+	return s.index.search(ctx, searchTerm)
+}
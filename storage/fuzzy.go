@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// FuzzyMatcher suggests existing shorts when someone asks for one that doesn't exist, the same way Postgres does with
+// levenshtein and difference: candidates have to be within MaxDistance edits of the missing short and share at least
+// MinPhonetic of the 4 Soundex characters with it. At most MaxSuggestions are returned.
+type FuzzyMatcher struct {
+	MaxDistance    int
+	MinPhonetic    int
+	MaxSuggestions int
+}
+
+// DefaultFuzzyMatcher uses the thresholds Postgres has always used
+var DefaultFuzzyMatcher = &FuzzyMatcher{MaxDistance: 4, MinPhonetic: 3, MaxSuggestions: 5}
+
+// Suggestion is an existing short that's similar to one that wasn't found
+type Suggestion struct {
+	Short string `json:"short"`
+	URL   string `json:"url"`
+	// Score is how similar Short is to what was asked for, from 0 to 1
+	Score float64 `json:"score"`
+}
+
+// FuzzyMatchError is returned by Load when a short doesn't exist but similar ones do. Its cause is ErrFuzzyMatchFound,
+// use Suggestions to get at what was found.
+type FuzzyMatchError struct {
+	// Suggestions are sorted best first
+	Suggestions []Suggestion
+}
+
+// NewFuzzyMatchError sorts suggestions best first, dropping repeats of the same short
+func NewFuzzyMatchError(suggestions []Suggestion) *FuzzyMatchError {
+	sorted := make([]Suggestion, len(suggestions))
+	copy(sorted, suggestions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Short < sorted[j].Short
+	})
+
+	seen := make(map[string]bool, len(sorted))
+	deduped := sorted[:0]
+	for _, suggestion := range sorted {
+		if !seen[suggestion.Short] {
+			seen[suggestion.Short] = true
+			deduped = append(deduped, suggestion)
+		}
+	}
+
+	return &FuzzyMatchError{Suggestions: deduped}
+}
+
+func (e *FuzzyMatchError) Error() string {
+	shorts := make([]string, 0, len(e.Suggestions))
+	for _, suggestion := range e.Suggestions {
+		shorts = append(shorts, suggestion.Short)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrFuzzyMatchFound, strings.Join(shorts, ", "))
+}
+
+// Cause lets errors.Cause(err) == ErrFuzzyMatchFound checks keep working
+func (e *FuzzyMatchError) Cause() error  { return ErrFuzzyMatchFound }
+func (e *FuzzyMatchError) Unwrap() error { return ErrFuzzyMatchFound }
+
+// Suggestions returns the suggestions carried by err if it is, or wraps, a FuzzyMatchError
+func Suggestions(err error) []Suggestion {
+	var fuzzyErr *FuzzyMatchError
+	if stderrors.As(err, &fuzzyErr) {
+		return fuzzyErr.Suggestions
+	}
+	return nil
+}
+
+// Suggest returns the candidates that are close enough to short, best first. Shorts are compared after sanitizing
+// them, so "Foo-Bar" and "foobar" are the same. The suggestions don't have URLs filled in.
+func (f *FuzzyMatcher) Suggest(short string, candidates []string) []Suggestion {
+	short = fuzzyKey(short)
+
+	var suggestions []Suggestion
+	for _, candidate := range candidates {
+		key := fuzzyKey(candidate)
+		if key == short || strings.Contains(key, "{}") {
+			// Exact matches and parameterized links aren't useful suggestions
+			continue
+		}
+
+		phonetic := difference(short, key)
+		if phonetic < f.MinPhonetic {
+			continue
+		}
+		distance := levenshtein(short, key)
+		if distance > f.MaxDistance {
+			continue
+		}
+
+		suggestions = append(suggestions, Suggestion{
+			Short: candidate,
+			Score: fuzzyScore(short, key, distance, phonetic),
+		})
+	}
+
+	suggestions = NewFuzzyMatchError(suggestions).Suggestions
+	if len(suggestions) > f.MaxSuggestions {
+		suggestions = suggestions[:f.MaxSuggestions]
+	}
+
+	return suggestions
+}
+
+// fuzzyScore averages how little editing it takes to turn a into b with how alike they sound, giving 1 for identical
+// shorts and 0 for ones with nothing in common
+func fuzzyScore(a, b string, distance int, phonetic int) float64 {
+	longest := max(len([]rune(a)), len([]rune(b)))
+	if longest == 0 {
+		return 1
+	}
+
+	edit := 1 - float64(distance)/float64(longest)
+	if edit < 0 {
+		edit = 0
+	}
+
+	return (edit + float64(phonetic)/4) / 2
+}
+
+func fuzzyKey(short string) string {
+	if sanitized, err := sanitizeShort(short); err == nil {
+		return sanitized
+	}
+	return short
+}
+
+// fuzzyNotFound is what a storage's Load returns when it doesn't have rawShort: a FuzzyMatchError with the closest of
+// its shorts (and their URLs from load) if f finds any, otherwise ErrShortNotSet. A nil f disables suggestions.
+func fuzzyNotFound(ctx context.Context, f *FuzzyMatcher, rawShort string, candidates func() ([]string, error), load func(ctx context.Context, short string) (string, error)) (string, error) {
+	if f == nil {
+		return "", ErrShortNotSet
+	}
+
+	shorts, err := candidates()
+	if err != nil {
+		// Not being able to make a suggestion isn't worth failing the whole request over
+		log.Printf("Failed to find fuzzy match candidates for %q: %s", rawShort, err)
+		return "", ErrShortNotSet
+	}
+
+	suggestions := f.Suggest(rawShort, shorts)
+	found := suggestions[:0]
+	for _, suggestion := range suggestions {
+		url, err := load(ctx, suggestion.Short)
+		if err != nil {
+			// Most likely deleted since we listed the candidates
+			continue
+		}
+
+		suggestion.URL = url
+		found = append(found, suggestion)
+	}
+
+	if len(found) == 0 {
+		return "", ErrShortNotSet
+	}
+	return "", &FuzzyMatchError{Suggestions: found}
+}
+
+// levenshtein is the number of single character insertions, deletions and substitutions it takes to turn a into b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+var soundexCodes = map[rune]byte{
+	'b': '1', 'f': '1', 'p': '1', 'v': '1',
+	'c': '2', 'g': '2', 'j': '2', 'k': '2', 'q': '2', 's': '2', 'x': '2', 'z': '2',
+	'd': '3', 't': '3',
+	'l': '4',
+	'm': '5', 'n': '5',
+	'r': '6',
+}
+
+// soundex is the 4 character American Soundex code for s, ignoring anything that isn't a letter. It's "" when s has
+// no letters.
+func soundex(s string) string {
+	var (
+		code []byte
+		last byte
+	)
+	for _, r := range strings.ToLower(s) {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			continue
+		}
+
+		c := soundexCodes[r]
+		if len(code) == 0 {
+			code = append(code, byte(unicode.ToUpper(r)))
+			last = c
+			continue
+		}
+
+		switch {
+		case c == 0 && r != 'h' && r != 'w':
+			// Vowels separate letters with the same code, h and w don't
+			last = 0
+		case c != 0 && c != last:
+			code = append(code, c)
+			last = c
+		}
+
+		if len(code) == 4 {
+			break
+		}
+	}
+
+	if len(code) == 0 {
+		return ""
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// difference is how many of the 4 Soundex characters a and b have in common, like Postgres' difference
+func difference(a, b string) int {
+	sa, sb := soundex(a), soundex(b)
+	if sa == "" || sb == "" {
+		return 0
+	}
+
+	var same int
+	for i := 0; i < 4; i++ {
+		if sa[i] == sb[i] {
+			same++
+		}
+	}
+	return same
+}
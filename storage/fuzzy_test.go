@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestLevenshtein(t *testing.T) {
+	testTable := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "", b: "", expected: 0},
+		{a: "docs", b: "", expected: 4},
+		{a: "kitten", b: "sitting", expected: 3},
+		{a: "grafana", b: "grafnaa", expected: 2},
+		{a: "café", b: "cafe", expected: 1},
+	}
+
+	for _, tt := range testTable {
+		if actual := levenshtein(tt.a, tt.b); actual != tt.expected {
+			t.Errorf("levenshtein(%q, %q): actual (%d) != expected (%d)", tt.a, tt.b, actual, tt.expected)
+		}
+	}
+}
+
+func TestSoundex(t *testing.T) {
+	testTable := map[string]string{
+		"Robert":   "R163",
+		"Rupert":   "R163",
+		"Rubin":    "R150",
+		"Ashcraft": "A261",
+		"Tymczak":  "T522",
+		"Pfister":  "P236",
+		"go-docs":  "G320",
+		"1234":     "",
+	}
+
+	for in, expected := range testTable {
+		if actual := soundex(in); actual != expected {
+			t.Errorf("soundex(%q): actual (%q) != expected (%q)", in, actual, expected)
+		}
+	}
+
+	if actual := difference("Robert", "Rupert"); actual != 4 {
+		t.Errorf("difference(Robert, Rupert): actual (%d) != expected (4)", actual)
+	}
+	if actual := difference("1234", "1235"); actual != 0 {
+		t.Errorf("difference(1234, 1235): actual (%d) != expected (0)", actual)
+	}
+}
+
+func TestFuzzyMatcherSuggest(t *testing.T) {
+	candidates := []string{"grafana", "graphs", "dashboards", "kubernetes-docs", "pr/{}"}
+
+	testTable := []struct {
+		in       string
+		matcher  *FuzzyMatcher
+		expected []string
+	}{
+		{in: "grafna", matcher: DefaultFuzzyMatcher, expected: []string{"grafana", "graphs"}},
+		{in: "grafna", matcher: &FuzzyMatcher{MaxDistance: 4, MinPhonetic: 3, MaxSuggestions: 1}, expected: []string{"grafana"}},
+		{in: "Kubernetes-Doc", matcher: DefaultFuzzyMatcher, expected: []string{"kubernetes-docs"}},
+		{in: "dashbord", matcher: DefaultFuzzyMatcher, expected: []string{"dashboards"}},
+		{in: "dashbord", matcher: &FuzzyMatcher{MaxDistance: 1, MinPhonetic: 3, MaxSuggestions: 5}, expected: nil},
+		{in: "jira", matcher: DefaultFuzzyMatcher, expected: nil},
+		{in: "pr", matcher: DefaultFuzzyMatcher, expected: nil},
+	}
+
+	for _, tt := range testTable {
+		var actual []string
+		for _, suggestion := range tt.matcher.Suggest(tt.in, candidates) {
+			actual = append(actual, suggestion.Short)
+		}
+
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("Suggest(%q): actual (%q) != expected (%q)", tt.in, actual, tt.expected)
+		}
+	}
+}
+
+func TestNewFuzzyMatchError(t *testing.T) {
+	err := NewFuzzyMatchError([]Suggestion{
+		{Short: "b", Score: 0.5},
+		{Short: "a", Score: 0.9},
+		{Short: "c", Score: 0.5},
+		{Short: "a", Score: 0.7},
+	})
+
+	var actual []string
+	for _, suggestion := range Suggestions(errors.Wrap(err, "wrapped")) {
+		actual = append(actual, suggestion.Short)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("actual suggestions (%q) != expected (%q)", actual, expected)
+	}
+
+	if cause := errors.Cause(errors.Wrap(err, "wrapped")); cause != ErrFuzzyMatchFound {
+		t.Errorf("actual cause (%v) != expected cause (%v)", cause, ErrFuzzyMatchFound)
+	}
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HitRecording controls how visits are queued up and written in batches
+type HitRecording struct {
+	// QueueSize is how many visits can be waiting to be written before new ones are dropped
+	QueueSize int
+	// BatchSize is how many visits are written at once
+	BatchSize int
+	// FlushInterval is the longest a visit waits before being written
+	FlushInterval time.Duration
+}
+
+var DefaultHitRecording = HitRecording{
+	QueueSize:     10000,
+	BatchSize:     500,
+	FlushInterval: time.Second,
+}
+
+// hitFlushTimeout is how long writing a batch of hits is allowed to take
+const hitFlushTimeout = 10 * time.Second
+
+var droppedHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "storage",
+		Name:      "hits_dropped_total",
+		Help:      "A counter for visits that were never recorded, because the queue was full, writing them failed or they came in after shutting down",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	prometheus.MustRegister(droppedHits)
+}
+
+type hitKey struct {
+	short string
+	day   time.Time
+}
+
+// hitCount is how many times a link was visited on a day, and when the last of them was
+type hitCount struct {
+	hits int
+	last time.Time
+}
+
+type hitEvent struct {
+	short string
+	at    time.Time
+}
+
+// hitRecorder queues visits in memory and writes them in batches from a background worker, so recording a visit
+// never waits on the write
+type hitRecorder struct {
+	HitRecording
+	flush func(ctx context.Context, batch map[hitKey]hitCount) error
+
+	events  chan hitEvent
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	mu     sync.RWMutex // Held for writing while closing, so nothing is queued after the worker's last write
+	closed bool
+}
+
+// newHitRecorder starts a worker writing visits with flush, anything in config that isn't set comes from
+// DefaultHitRecording
+func newHitRecorder(config HitRecording, flush func(ctx context.Context, batch map[hitKey]hitCount) error) *hitRecorder {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultHitRecording.QueueSize
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultHitRecording.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultHitRecording.FlushInterval
+	}
+
+	r := &hitRecorder{
+		HitRecording: config,
+		flush:        flush,
+
+		events:  make(chan hitEvent, config.QueueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go r.run()
+
+	return r
+}
+
+// record queues a visit to short, returning false if it was dropped because the queue is full or the recorder was
+// closed
+func (r *hitRecorder) record(short string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		droppedHits.WithLabelValues("closed").Inc()
+		return false
+	}
+
+	select {
+	case r.events <- hitEvent{short, time.Now()}:
+		return true
+	default:
+		droppedHits.WithLabelValues("queue_full").Inc()
+		return false
+	}
+}
+
+// Close writes out every queued visit and stops the worker, visits recorded afterwards are dropped and counted in
+// droppedHits
+func (r *hitRecorder) Close() error {
+	r.once.Do(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.closed = true
+		close(r.stop)
+	})
+	<-r.stopped
+
+	return nil
+}
+
+func (r *hitRecorder) run() {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(r.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch   = make(map[hitKey]hitCount)
+		pending int
+	)
+	write := func() {
+		if pending == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), hitFlushTimeout)
+		defer cancel()
+
+		if err := r.flush(ctx, batch); err != nil {
+			log.Printf("Error recording %d visits: %s", pending, err)
+			droppedHits.WithLabelValues("flush_failed").Add(float64(pending))
+		}
+		batch, pending = make(map[hitKey]hitCount), 0
+	}
+	add := func(event hitEvent) {
+		key := hitKey{event.short, usageDay(event.at)}
+		count := batch[key]
+		count.hits++
+		if event.at.After(count.last) {
+			count.last = event.at
+		}
+		batch[key] = count
+
+		if pending++; pending >= r.BatchSize {
+			write()
+		}
+	}
+
+	for {
+		select {
+		case event := <-r.events:
+			add(event)
+		case <-ticker.C:
+			write()
+		case <-r.stop:
+			for {
+				select {
+				case event := <-r.events:
+					add(event)
+				default:
+					write()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHitFlusher remembers every batch it's given
+type fakeHitFlusher struct {
+	batches []map[hitKey]hitCount
+	mu      sync.Mutex
+}
+
+func (f *fakeHitFlusher) flush(ctx context.Context, batch map[hitKey]hitCount) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.batches = append(f.batches, batch)
+	return nil
+}
+
+// hits sums up how many times each short was visited across every batch
+func (f *fakeHitFlusher) hits() (batches int, hits map[string]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	hits = make(map[string]int)
+	for _, batch := range f.batches {
+		for key, count := range batch {
+			hits[key.short] += count.hits
+		}
+	}
+	return len(f.batches), hits
+}
+
+func TestHitRecorderBatches(t *testing.T) {
+	f := &fakeHitFlusher{}
+	r := newHitRecorder(HitRecording{QueueSize: 100, BatchSize: 3, FlushInterval: time.Hour}, f.flush)
+
+	for _, short := range []string{"a", "a", "b", "a"} {
+		require.True(t, r.record(short))
+	}
+
+	require.Eventually(t, func() bool {
+		batches, _ := f.hits()
+		return batches == 1
+	}, time.Second, time.Millisecond, "a full batch should be written straight away")
+
+	// The last visit is only written when the recorder is closed
+	require.Nil(t, r.Close())
+	batches, hits := f.hits()
+	require.Equal(t, 2, batches)
+	require.Equal(t, map[string]int{"a": 3, "b": 1}, hits)
+
+	require.Nil(t, r.Close(), "closing twice should be fine")
+}
+
+func TestHitRecorderFlushInterval(t *testing.T) {
+	f := &fakeHitFlusher{}
+	r := newHitRecorder(HitRecording{QueueSize: 100, BatchSize: 100, FlushInterval: 10 * time.Millisecond}, f.flush)
+	defer r.Close()
+
+	require.True(t, r.record("a"))
+	require.Eventually(t, func() bool {
+		_, hits := f.hits()
+		return hits["a"] == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestHitRecorderDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	r := newHitRecorder(HitRecording{QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour}, func(ctx context.Context, batch map[hitKey]hitCount) error {
+		<-release
+		return nil
+	})
+
+	// The first visit is being written, the second waits in the queue and the rest have nowhere to go
+	require.True(t, r.record("a"))
+	require.Eventually(t, func() bool {
+		return len(r.events) == 0
+	}, time.Second, time.Millisecond)
+	require.True(t, r.record("b"))
+	require.False(t, r.record("c"))
+
+	close(release)
+	require.Nil(t, r.Close())
+}
+
+func TestHitRecorderDefaults(t *testing.T) {
+	f := &fakeHitFlusher{}
+	r := newHitRecorder(HitRecording{}, f.flush)
+
+	require.Equal(t, DefaultHitRecording, r.HitRecording)
+	require.Equal(t, DefaultHitRecording.QueueSize, cap(r.events))
+
+	// A zero BatchSize would've written every visit on its own
+	require.True(t, r.record("a"))
+	require.True(t, r.record("a"))
+	require.Nil(t, r.Close())
+
+	batches, hits := f.hits()
+	require.Equal(t, 1, batches)
+	require.Equal(t, map[string]int{"a": 2}, hits)
+}
+
+func TestHitRecorderDropsWhenClosed(t *testing.T) {
+	f := &fakeHitFlusher{}
+	r := newHitRecorder(HitRecording{QueueSize: 100, BatchSize: 100, FlushInterval: time.Hour}, f.flush)
+	require.Nil(t, r.Close())
+
+	dropped := testutil.ToFloat64(droppedHits.WithLabelValues("closed"))
+	require.False(t, r.record("a"))
+	require.Equal(t, dropped+1, testutil.ToFloat64(droppedHits.WithLabelValues("closed")))
+	require.Empty(t, r.events)
+}
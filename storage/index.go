@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// linkIndex is an in memory copy of a storage's links, for backends where looking at every link is slow. It's filled
+// from list the first time it's used, kept up to date as links are saved and deleted through the same storage, and
+// refilled once it's older than maxAge to pick up changes made by anyone else. The old copy keeps being used while it's
+// being refilled.
+type linkIndex struct {
+	list   func(ctx context.Context, cursor string, limit int) ([]ListResult, string, error)
+	maxAge time.Duration
+
+	links    map[string]indexedLink
+	loadedAt time.Time
+	pending  map[string]*indexedLink // Links set (or deleted, nil) while refilling, which the listing might've missed
+	mu       sync.Mutex
+
+	refreshing sync.Mutex // Held while refilling, so only one refill lists the links at a time
+}
+
+// indexedLink is a link's URL along with the trigrams search needs
+type indexedLink struct {
+	url           string
+	shortTrigrams trigramSet
+	urlTrigrams   trigramSet
+}
+
+func newIndexedLink(short string, url string) indexedLink {
+	return indexedLink{
+		url:           url,
+		shortTrigrams: trigrams(short),
+		urlTrigrams:   trigrams(url),
+	}
+}
+
+func newLinkIndex(list func(ctx context.Context, cursor string, limit int) ([]ListResult, string, error), maxAge time.Duration) *linkIndex {
+	return &linkIndex{
+		list:   list,
+		maxAge: maxAge,
+	}
+}
+
+// shorts returns every short in the index
+func (i *linkIndex) shorts(ctx context.Context) ([]string, error) {
+	if err := i.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	shorts := make([]string, 0, len(i.links))
+	for short := range i.links {
+		shorts = append(shorts, short)
+	}
+
+	return shorts, nil
+}
+
+// search returns the links whose short or URL is similar to searchTerm, most relevant first. Like Postgres.Search a
+// link's relevance is the sum of how similar its short and its URL are to the term, counting only the ones over
+// searchSimilarityThreshold.
+func (i *linkIndex) search(ctx context.Context, searchTerm string) ([]SearchResult, error) {
+	if err := i.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	term := trigrams(searchTerm)
+
+	var results []SearchResult
+	relevance := make(map[string]float64)
+	for short, link := range i.links {
+		var score float64
+		for _, sml := range []float64{term.similarity(link.shortTrigrams), term.similarity(link.urlTrigrams)} {
+			if sml > searchSimilarityThreshold {
+				score += sml
+			}
+		}
+		if score == 0 {
+			continue
+		}
+
+		relevance[short] = score
+		results = append(results, SearchResult{
+			Link: short,
+			URL:  link.url,
+		})
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if ra, rb := relevance[results[a].Link], relevance[results[b].Link]; ra != rb {
+			return ra > rb
+		}
+		return results[a].Link < results[b].Link
+	})
+
+	return results, nil
+}
+
+// filled returns whether the index has links in it, even if they're too old
+func (i *linkIndex) filled() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.links != nil
+}
+
+// refresh refills the index if it's empty or too old. The links are listed without holding i.mu, and if another
+// refresh is already listing them we carry on with the old links rather than waiting for it, unless there aren't any.
+func (i *linkIndex) refresh(ctx context.Context) error {
+	if !i.refreshing.TryLock() {
+		if i.filled() {
+			return nil
+		}
+		i.refreshing.Lock()
+	}
+	defer i.refreshing.Unlock()
+
+	i.mu.Lock()
+	if i.links != nil && time.Since(i.loadedAt) < i.maxAge {
+		i.mu.Unlock()
+		return nil
+	}
+	i.pending = make(map[string]*indexedLink)
+	i.mu.Unlock()
+
+	results, _, err := i.list(ctx, "", 0)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	pending := i.pending
+	i.pending = nil
+	if err != nil {
+		return errors.Wrap(err, "failed to fill link index")
+	}
+
+	links := make(map[string]indexedLink, len(results))
+	for _, result := range results {
+		links[result.Link] = newIndexedLink(result.Link, result.URL)
+	}
+	for short, link := range pending {
+		if link == nil {
+			delete(links, short)
+		} else {
+			links[short] = *link
+		}
+	}
+
+	i.links, i.loadedAt = links, time.Now()
+	return nil
+}
+
+func (i *linkIndex) set(short string, url string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	link := newIndexedLink(short, url)
+	if i.links != nil {
+		i.links[short] = link
+	}
+	if i.pending != nil {
+		i.pending[short] = &link
+	}
+}
+
+func (i *linkIndex) delete(short string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.links, short)
+	if i.pending != nil {
+		i.pending[short] = nil
+	}
+}
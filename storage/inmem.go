@@ -4,20 +4,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type Inmem struct {
 	RandLength int
+	// Fuzzy suggests a similar short when one isn't found, nil disables suggestions
+	Fuzzy *FuzzyMatcher
+	// UsageRetentionDays is how many days of visits are kept for TopNForPeriod, older days are dropped
+	UsageRetentionDays int
 
 	m      map[string]string
-	visits map[string]int
+	meta   map[string]LinkMetadata
+	visits map[string]map[time.Time]int // Visits to each short, bucketed by day
+	access map[string]time.Time         // When each short was last visited
+	pruned time.Time                    // The day visits were last pruned
 	mu     sync.RWMutex
 }
 
+// DefaultInmemUsageRetentionDays keeps enough visits for the dashboard's year view
+const DefaultInmemUsageRetentionDays = 366
+
+// inmemNow is when Inmem thinks it is, so tests can move it through the days
+var inmemNow = time.Now
+
 func (s *Inmem) String() string {
 	j := struct {
 		RandLength int
@@ -34,10 +49,13 @@ func (s *Inmem) String() string {
 
 func NewInmem(randLength int) (*Inmem, error) {
 	s := &Inmem{
-		RandLength: randLength,
+		RandLength:         randLength,
+		UsageRetentionDays: DefaultInmemUsageRetentionDays,
 
 		m:      make(map[string]string),
-		visits: make(map[string]int),
+		meta:   make(map[string]LinkMetadata),
+		visits: make(map[string]map[time.Time]int),
+		access: make(map[string]time.Time),
 	}
 	return s, nil
 }
@@ -55,21 +73,135 @@ func NewInmemFromMap(randLength int, initialShorts map[string]string) (*Inmem, e
 }
 
 func (s *Inmem) SaveName(ctx context.Context, rawShort string, url string) error {
-	short, err := sanitizeShort(rawShort)
+	short, url, err := validateLink(rawShort, url)
 	if err != nil {
 		return err
 	}
+
+	s.mu.Lock()
+	s.m[short] = url
+	s.meta[short] = updateMetadata(s.meta[short], UserFromContext(ctx))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Inmem) Save(ctx context.Context, url string) (string, error) {
 	if _, err := validateURL(url); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return GenerateShort(s.RandLength, func(short string) (bool, error) {
+		if _, ok := s.m[short]; ok {
+			return false, nil
+		}
+
+		s.m[short] = url
+		s.meta[short] = updateMetadata(LinkMetadata{}, UserFromContext(ctx))
+		return true, nil
+	})
+}
+
+// GeneratedLength returns how many characters the shorts Save generates have
+func (s *Inmem) GeneratedLength() int {
+	return RandLength(s.RandLength)
+}
+
+// CanonicalShort returns the lowercased short, without the characters that are ignored in shorts
+func (s *Inmem) CanonicalShort(rawShort string) (string, error) {
+	return storedShort(rawShort)
+}
+
+func (s *Inmem) Delete(ctx context.Context, rawShort string) error {
+	rawShort, _, err := templateShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
 		return err
 	}
 
 	s.mu.Lock()
-	s.m[short] = url
-	s.mu.Unlock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.m[short]; !ok {
+		return ErrShortNotSet
+	}
+
+	delete(s.m, short)
+	delete(s.meta, short)
+	delete(s.visits, short)
+	delete(s.access, short)
 	return nil
 }
 
 func (s *Inmem) Load(ctx context.Context, rawShort string) (string, error) {
+	url, err := s.resolve(ctx, rawShort)
+	if err == ErrShortNotSet {
+		return fuzzyNotFound(ctx, s.Fuzzy, rawShort, s.shorts, s.peek)
+	}
+
+	return url, err
+}
+
+// RecordVisit counts a visit to short the same way Load does, including not counting it if ctx is from WithoutVisit
+func (s *Inmem) RecordVisit(ctx context.Context, rawShort string) error {
+	_, err := s.resolve(ctx, rawShort)
+	return err
+}
+
+// resolve looks up short, or the parameterized link it matches, counting a visit unless ctx is from WithoutVisit
+func (s *Inmem) resolve(ctx context.Context, rawShort string) (string, error) {
+	url, err := s.loadExact(ctx, rawShort)
+	if err == ErrShortNotSet {
+		url, err = resolveTemplate(ctx, rawShort, s.loadExact)
+	}
+
+	return url, err
+}
+
+// LoadExact is Load without resolving parameterized links or counting a visit
+func (s *Inmem) LoadExact(ctx context.Context, rawShort string) (string, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return "", err
+	}
+
+	return s.peek(ctx, short)
+}
+
+// peek looks up a stored short without counting it as a visit
+func (s *Inmem) peek(ctx context.Context, short string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	url, ok := s.m[short]
+	if !ok {
+		return "", ErrShortNotSet
+	}
+
+	return url, nil
+}
+
+// shorts returns every short s has
+func (s *Inmem) shorts() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	shorts := make([]string, 0, len(s.m))
+	for short := range s.m {
+		shorts = append(shorts, short)
+	}
+
+	return shorts, nil
+}
+
+// loadExact looks up a single short without trying to resolve parameterized links
+func (s *Inmem) loadExact(ctx context.Context, rawShort string) (string, error) {
 	short, err := sanitizeShort(rawShort)
 	if err != nil {
 		return "", err
@@ -83,28 +215,167 @@ func (s *Inmem) Load(ctx context.Context, rawShort string) (string, error) {
 		return "", ErrShortNotSet
 	}
 
-	if short != "healthcheck" {
-		s.visits[short]++
+	if short != "healthcheck" && countsVisits(ctx) {
+		s.visit(short)
 	}
 
 	return url, nil
 }
 
+// usageDay is the day t falls on, as the UTC midnight Postgres uses for links_usage days
+func usageDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// visit counts a visit to short today, callers must hold s.mu for writing
+func (s *Inmem) visit(short string) {
+	now := inmemNow()
+	s.access[short] = now
+
+	today := usageDay(now)
+	if today != s.pruned {
+		s.pruneVisits(today)
+	}
+
+	days, ok := s.visits[short]
+	if !ok {
+		days = make(map[time.Time]int)
+		s.visits[short] = days
+	}
+	days[today]++
+}
+
+// pruneVisits drops the days that are older than UsageRetentionDays, callers must hold s.mu for writing
+func (s *Inmem) pruneVisits(today time.Time) {
+	s.pruned = today
+	if s.UsageRetentionDays <= 0 {
+		return
+	}
+
+	oldest := today.AddDate(0, 0, -s.UsageRetentionDays)
+	for short, days := range s.visits {
+		for day := range days {
+			if day.Before(oldest) {
+				delete(days, day)
+			}
+		}
+		if len(days) == 0 {
+			delete(s.visits, short)
+		}
+	}
+}
+
+func (s *Inmem) Metadata(ctx context.Context, rawShort string) (LinkMetadata, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.m[short]; !ok {
+		return LinkMetadata{}, ErrShortNotSet
+	}
+
+	return s.meta[short], nil
+}
+
+func (s *Inmem) List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []ListResult
+	for short, url := range s.m {
+		if short > cursor {
+			results = append(results, ListResult{
+				Link: short,
+				URL:  url,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Link < results[j].Link
+	})
+
+	results, next := pageListResults(results, limit)
+	return results, next, nil
+}
+
+// TopNForPeriod returns the n most visited shorts from today and the days before it, like Postgres' links_usage
 func (s *Inmem) TopNForPeriod(ctx context.Context, n int, days int) ([]TopNResult, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	since := usageDay(inmemNow()).AddDate(0, 0, -days)
 
 	var results []TopNResult
 	for short, visits := range s.visits {
+		var hits int
+		for day, count := range visits {
+			if !day.Before(since) {
+				hits += count
+			}
+		}
+		if hits == 0 {
+			continue
+		}
+
 		results = append(results, TopNResult{
 			Link:     short,
-			HitCount: visits,
+			HitCount: hits,
 		})
 	}
 
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].HitCount != results[j].HitCount {
+			return results[i].HitCount > results[j].HitCount
+		}
+		return results[i].Link < results[j].Link
+	})
+
+	if n >= 0 && len(results) > n {
+		results = results[:n]
+	}
+
 	return results, nil
 }
 
+func (s *Inmem) Stats(ctx context.Context, rawShort string, days int) (LinkStats, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return LinkStats{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.m[short]; !ok {
+		return LinkStats{}, ErrShortNotSet
+	}
+
+	since := usageDay(inmemNow()).AddDate(0, 0, -days)
+
+	stats := LinkStats{Usage: []UsageEntry{}}
+	for day, count := range s.visits[short] {
+		if !day.Before(since) {
+			stats.Usage = append(stats.Usage, UsageEntry{Day: day, HitCount: count})
+			stats.TotalHits += count
+		}
+	}
+	sort.Slice(stats.Usage, func(i, j int) bool {
+		return stats.Usage[i].Day.Before(stats.Usage[j].Day)
+	})
+
+	if lastAccessed, ok := s.access[short]; ok {
+		stats.LastAccessed = &lastAccessed
+	}
+
+	return stats, nil
+}
+
 func (s *Inmem) Search(ctx context.Context, searchTerm string) ([]SearchResult, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
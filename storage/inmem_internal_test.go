@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInmemTopNForPeriod(t *testing.T) {
+	s, err := NewInmemFromMap(8, map[string]string{
+		"docs":    "https://docs.example.com",
+		"grafana": "https://grafana.example.com",
+		"wiki":    "https://wiki.example.com",
+	})
+	require.Nil(t, err)
+	s.UsageRetentionDays = 31
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	inmemNow = func() time.Time { return now }
+	defer func() { inmemNow = time.Now }()
+
+	visit := func(short string, times int) {
+		for i := 0; i < times; i++ {
+			_, err := s.Load(context.Background(), short)
+			require.Nil(t, err)
+		}
+	}
+
+	visit("wiki", 10)
+	now = now.AddDate(0, 0, 10)
+	visit("grafana", 3)
+	visit("docs", 1)
+	now = now.AddDate(0, 0, 1)
+	visit("docs", 3)
+
+	results, err := s.TopNForPeriod(context.Background(), 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, []TopNResult{{Link: "docs", HitCount: 3}}, results)
+
+	results, err = s.TopNForPeriod(context.Background(), 10, 1)
+	require.Nil(t, err)
+	require.Equal(t, []TopNResult{{Link: "docs", HitCount: 4}, {Link: "grafana", HitCount: 3}}, results)
+
+	results, err = s.TopNForPeriod(context.Background(), 2, 31)
+	require.Nil(t, err)
+	require.Equal(t, []TopNResult{{Link: "wiki", HitCount: 10}, {Link: "docs", HitCount: 4}}, results)
+
+	// Visits older than UsageRetentionDays are dropped the next time a short is visited on a new day
+	now = now.AddDate(0, 0, 25)
+	visit("grafana", 1)
+
+	results, err = s.TopNForPeriod(context.Background(), 10, 100000)
+	require.Nil(t, err)
+	require.Equal(t, []TopNResult{{Link: "docs", HitCount: 4}, {Link: "grafana", HitCount: 4}}, results)
+}
+
+func TestInmemStats(t *testing.T) {
+	s, err := NewInmemFromMap(8, map[string]string{
+		"docs": "https://docs.example.com",
+		"wiki": "https://wiki.example.com",
+	})
+	require.Nil(t, err)
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	inmemNow = func() time.Time { return now }
+	defer func() { inmemNow = time.Now }()
+
+	for _, days := range []int{5, 1, 0} {
+		now = time.Date(2024, 3, 10-days, 12, 0, 0, 0, time.Local)
+		for i := 0; i <= days; i++ {
+			_, err := s.Load(context.Background(), "docs")
+			require.Nil(t, err)
+		}
+	}
+
+	stats, err := s.Stats(context.Background(), "docs", 1)
+	require.Nil(t, err)
+	require.Equal(t, LinkStats{
+		Usage: []UsageEntry{
+			{Day: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC), HitCount: 2},
+			{Day: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), HitCount: 1},
+		},
+		TotalHits:    3,
+		LastAccessed: &now,
+	}, stats)
+
+	stats, err = s.Stats(context.Background(), "docs", 30)
+	require.Nil(t, err)
+	require.Equal(t, 9, stats.TotalHits)
+	require.Len(t, stats.Usage, 3)
+
+	// Links nobody has visited still have stats
+	stats, err = s.Stats(context.Background(), "wiki", 30)
+	require.Nil(t, err)
+	require.Equal(t, LinkStats{Usage: []UsageEntry{}}, stats)
+
+	_, err = s.Stats(context.Background(), "missing", 30)
+	require.Equal(t, ErrShortNotSet, err)
+}
@@ -13,7 +13,11 @@ type S3v2MigrationStore struct {
 }
 
 func (s *S3v2MigrationStore) Load(ctx context.Context, short string) (long string, err error) {
-	long, err = s.S3.Load(ctx, short)
+	long, err = s.S3.LoadExact(ctx, short)
+	if err == storage.ErrShortNotSet {
+		// Parameterized links were introduced after v3 so never need migrating
+		return s.S3.Load(ctx, short)
+	}
 	if err != nil {
 		return
 	}
@@ -0,0 +1,164 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+// copyPageSize is how many links Copy lists from the source at a time
+const copyPageSize = 500
+
+// CopyStats describes how far a Copy got
+type CopyStats struct {
+	Copied  int
+	Skipped int
+
+	// LastShort is the last short that was completely copied, pass it as startAfter to resume a failed Copy
+	LastShort string
+}
+
+// Copy copies every link from one storage into another, starting after the short startAfter ("" for the beginning).
+// History and usage are copied too when both storages support them. Links that already point at the same URL in the
+// destination (a storage.ExactStorage) are skipped and everything else overwrites what was there, so Copy is safe to
+// re-run. Each link is checked in the destination as it's copied, so the destination is never listed.
+func Copy(ctx context.Context, from storage.ListableStorage, to storage.NamedStorage, startAfter string) (CopyStats, error) {
+	stats := CopyStats{LastShort: startAfter}
+
+	cursor := startAfter
+	for {
+		results, next, err := from.List(ctx, cursor, copyPageSize)
+		if err != nil {
+			return stats, errors.Wrapf(err, "failed to list source after %q", cursor)
+		}
+
+		for _, result := range results {
+			copied, err := copyLink(ctx, from, to, result)
+			if err != nil {
+				return stats, errors.Wrapf(err, "failed to copy %q", result.Link)
+			}
+
+			if copied {
+				stats.Copied++
+			} else {
+				stats.Skipped++
+			}
+			stats.LastShort = result.Link
+		}
+
+		log.Printf("Copied %d links, skipped %d, up to %q", stats.Copied, stats.Skipped, stats.LastShort)
+
+		if next == "" {
+			return stats, nil
+		}
+		cursor = next
+	}
+}
+
+// existingURL returns the URL saved under short in store, or false if it doesn't have the short or can't tell us
+// without resolving parameterized links and the like
+func existingURL(ctx context.Context, store storage.Storage, short string) (string, bool, error) {
+	es, ok := store.(storage.ExactStorage)
+	if !ok {
+		return "", false, nil
+	}
+
+	url, err := es.LoadExact(ctx, short)
+	if errors.Cause(err) == storage.ErrShortNotSet {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to load from destination")
+	}
+
+	return url, true, nil
+}
+
+func copyLink(ctx context.Context, from storage.Storage, to storage.NamedStorage, link storage.ListResult) (bool, error) {
+	copied, err := copyHistory(ctx, from, to, link)
+	if err != nil {
+		return false, err
+	}
+
+	var upToDate bool
+	if !copied {
+		url, ok, err := existingURL(ctx, to, link.Link)
+		if err != nil {
+			return false, err
+		}
+		upToDate = ok && url == link.URL
+	}
+
+	if !copied && !upToDate {
+		saveCtx := ctx
+		if ms, ok := from.(storage.MetadataStorage); ok {
+			md, err := ms.Metadata(ctx, link.Link)
+			if err != nil {
+				return false, errors.Wrap(err, "failed to load metadata")
+			}
+			saveCtx = storage.WithUser(ctx, md.LastEditor)
+		}
+
+		if err := to.SaveName(saveCtx, link.Link, link.URL); err != nil {
+			return false, err
+		}
+		copied = true
+	}
+
+	// Usage is replaced rather than added to, so it is always safe to copy again
+	if err := copyUsage(ctx, from, to, link.Link); err != nil {
+		return false, err
+	}
+
+	return copied, nil
+}
+
+// copyHistory imports the source's history into the destination if they both support it, returning whether it did
+func copyHistory(ctx context.Context, from storage.Storage, to storage.Storage, link storage.ListResult) (bool, error) {
+	hs, ok := from.(storage.HistoryStorage)
+	if !ok {
+		return false, nil
+	}
+	hi, ok := to.(storage.HistoryImporter)
+	if !ok {
+		return false, nil
+	}
+
+	history, err := hs.History(ctx, link.Link)
+	if errors.Cause(err) == storage.ErrShortNotSet {
+		// No history to bring along
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to load history")
+	}
+
+	if err := hi.ImportHistory(ctx, link.Link, link.URL, history); err != nil {
+		return false, errors.Wrap(err, "failed to import history")
+	}
+
+	return true, nil
+}
+
+func copyUsage(ctx context.Context, from storage.Storage, to storage.Storage, short string) error {
+	us, ok := from.(storage.UsageStorage)
+	if !ok {
+		return nil
+	}
+	ui, ok := to.(storage.UsageImporter)
+	if !ok {
+		return nil
+	}
+
+	usage, err := us.Usage(ctx, short)
+	if err != nil {
+		return errors.Wrap(err, "failed to load usage")
+	}
+	if len(usage) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(ui.ImportUsage(ctx, short, usage), "failed to import usage")
+}
@@ -0,0 +1,50 @@
+package migrations_test
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomasdesr/go-shorten/storage"
+	"github.com/thomasdesr/go-shorten/storage/migrations"
+)
+
+func TestCopy(t *testing.T) {
+	from, err := storage.NewInmemFromMap(8, map[string]string{
+		"a": "http://A",
+		"b": "http://B",
+		"c": "http://C",
+	})
+	require.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "TestCopy")
+	require.Nil(t, err)
+	to, err := storage.NewFilesystem(dir)
+	require.Nil(t, err)
+
+	require.Nil(t, to.SaveName(context.Background(), "b", "http://B"))
+	require.Nil(t, to.SaveName(context.Background(), "c", "http://Old"))
+
+	stats, err := migrations.Copy(context.Background(), from, to, "")
+	t.Logf("migrations.Copy() -> %#v, %#v", stats, err)
+	require.Nil(t, err)
+	assert.Equal(t, migrations.CopyStats{Copied: 2, Skipped: 1, LastShort: "c"}, stats)
+
+	for short, expected := range map[string]string{"a": "http://A", "b": "http://B", "c": "http://C"} {
+		long, err := to.Load(context.Background(), short)
+		assert.Nil(t, err, short)
+		assert.Equal(t, expected, long, short)
+	}
+
+	// Running it again shouldn't have anything left to do
+	stats, err = migrations.Copy(context.Background(), from, to, "")
+	require.Nil(t, err)
+	assert.Equal(t, migrations.CopyStats{Copied: 0, Skipped: 3, LastShort: "c"}, stats)
+
+	// Resuming only looks at what's left
+	stats, err = migrations.Copy(context.Background(), from, to, "b")
+	require.Nil(t, err)
+	assert.Equal(t, migrations.CopyStats{Copied: 0, Skipped: 1, LastShort: "c"}, stats)
+}
@@ -2,6 +2,7 @@ package multistorage
 
 import (
 	"context"
+	"log"
 
 	"github.com/pkg/errors"
 	"github.com/thomasdesr/go-shorten/storage"
@@ -15,17 +16,47 @@ func loadFirstFunc(ctx context.Context, short string, stores []storage.NamedStor
 		return "", ErrEmpty
 	}
 
+	// Suggestions from one store shouldn't hide the real link in a later one, so only fall back to them once every
+	// store has missed
+	var suggestions [][]storage.Suggestion
 	for _, store := range stores {
 		long, err := store.Load(ctx, short)
-		if err == storage.ErrShortNotSet {
+		switch errors.Cause(err) {
+		case storage.ErrShortNotSet:
+			continue
+		case storage.ErrFuzzyMatchFound:
+			suggestions = append(suggestions, storage.Suggestions(err))
 			continue
 		}
 
 		return long, err
 	}
+
+	if len(suggestions) > 0 {
+		return "", mergeSuggestions(suggestions)
+	}
 	return "", storage.ErrShortNotSet
 }
 
+// mergeSuggestions combines the suggestions from several stores, keeping as many as the store with the most gave
+func mergeSuggestions(suggestions [][]storage.Suggestion) error {
+	var (
+		all   []storage.Suggestion
+		limit int
+	)
+	for _, s := range suggestions {
+		all = append(all, s...)
+		limit = max(limit, len(s))
+	}
+
+	merged := storage.NewFuzzyMatchError(all)
+	if len(merged.Suggestions) > limit {
+		merged.Suggestions = merged.Suggestions[:limit]
+	}
+
+	return merged
+}
+
 var ErrUnexpectedMultipleAnswers = errors.New("MultiStorage: results returned were not the same")
 
 func loadCompareAllResultsFunc(ctx context.Context, short string, stores []storage.NamedStorage) (string, error) {
@@ -33,9 +64,14 @@ func loadCompareAllResultsFunc(ctx context.Context, short string, stores []stora
 		return "", ErrEmpty
 	}
 
+	// Only the store whose answer is returned counts the visit, rather than every store that has the short
 	results := make([]loadResult, 0, len(stores))
 	for _, store := range stores {
-		s, err := store.Load(ctx, short)
+		s, err := store.Load(storage.WithoutVisit(ctx), short)
+		if errors.Cause(err) == storage.ErrFuzzyMatchFound {
+			// Suggestions are only a guess, for comparing answers they're the same as not having the short
+			s, err = "", storage.ErrShortNotSet
+		}
 
 		results = append(results, loadResult{s, err})
 	}
@@ -53,9 +89,25 @@ func loadCompareAllResultsFunc(ctx context.Context, short string, stores []stora
 		panic("something went very wrong, all of the backends returned empty strings for longs and no error")
 	}
 
+	if res.err == nil {
+		recordVisit(ctx, stores[0], short)
+	}
 	return res.long, res.err
 }
 
+// recordVisit counts a visit to short in the store whose answer a loader returned, for loaders that asked several
+// stores without counting visits so that a visit isn't counted once per store that has the short
+func recordVisit(ctx context.Context, store storage.NamedStorage, short string) {
+	vs, ok := store.(storage.VisitStorage)
+	if !ok {
+		return
+	}
+
+	if err := vs.RecordVisit(ctx, short); err != nil {
+		log.Printf("Error recording visit to %q in %v: %s", short, store, err)
+	}
+}
+
 type loadResult struct {
 	long string
 	err  error
@@ -69,3 +121,150 @@ func allSameLoadResults(res []loadResult) bool {
 	}
 	return true
 }
+
+// indexedLoadResult is what the store at index returned
+type indexedLoadResult struct {
+	index int
+	loadResult
+}
+
+// loadAll loads short from every store at the same time, sending each store's result as it comes in. The channel is
+// buffered for every store, so callers can stop reading (and cancel ctx) once they have the answer they need. Nothing
+// is counted as a visit, callers recordVisit in the store whose answer they use.
+func loadAll(ctx context.Context, short string, stores []storage.NamedStorage) <-chan indexedLoadResult {
+	ctx = storage.WithoutVisit(ctx)
+
+	results := make(chan indexedLoadResult, len(stores))
+	for i, store := range stores {
+		go func(i int, store storage.NamedStorage) {
+			long, err := store.Load(ctx, short)
+			results <- indexedLoadResult{i, loadResult{long, err}}
+		}(i, store)
+	}
+
+	return results
+}
+
+// loadFirstParallelFunc returns the same answer as loadFirstFunc, but asks every store at once. It returns as soon as
+// a store has an answer and every store before it has missed, without waiting for the stores after it.
+func loadFirstParallelFunc(ctx context.Context, short string, stores []storage.NamedStorage) (string, error) {
+	if len(stores) == 0 {
+		return "", ErrEmpty
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Stops the lower priority stores we didn't need
+
+	results := loadAll(ctx, short, stores)
+
+	var (
+		answers     = make([]*loadResult, len(stores))
+		next        int // The highest priority store we're still waiting on
+		suggestions [][]storage.Suggestion
+	)
+	for next < len(stores) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case res := <-results:
+			answers[res.index] = &res.loadResult
+		}
+
+		for ; next < len(stores) && answers[next] != nil; next++ {
+			res := answers[next]
+			switch errors.Cause(res.err) {
+			case storage.ErrShortNotSet:
+				continue
+			case storage.ErrFuzzyMatchFound:
+				suggestions = append(suggestions, storage.Suggestions(res.err))
+				continue
+			}
+
+			if res.err == nil {
+				recordVisit(ctx, stores[next], short)
+			}
+			return res.long, res.err
+		}
+	}
+
+	if len(suggestions) > 0 {
+		return "", mergeSuggestions(suggestions)
+	}
+	return "", storage.ErrShortNotSet
+}
+
+var ErrNoQuorum = errors.New("MultiStorage: not enough stores agreed on the result")
+
+var ErrInvalidQuorum = errors.New("MultiStorage: quorum must be between 1 and the number of stores")
+
+// loadQuorumFunc asks every store at once and returns the first answer quorum of them agree on. A store not having the
+// short is an answer like any other, while stores that fail don't count towards any answer. It returns ErrNoQuorum as
+// soon as no answer can reach quorum.
+func loadQuorumFunc(quorum int) Loader {
+	return func(ctx context.Context, short string, stores []storage.NamedStorage) (string, error) {
+		if len(stores) == 0 {
+			return "", ErrEmpty
+		}
+		if quorum < 1 || quorum > len(stores) {
+			return "", ErrInvalidQuorum
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := loadAll(ctx, short, stores)
+
+		var (
+			votes       = make(map[loadResult]int)
+			all         []loadResult
+			suggestions [][]storage.Suggestion
+		)
+		for received := 1; received <= len(stores); received++ {
+			var indexed indexedLoadResult
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case indexed = <-results:
+			}
+			res := indexed.loadResult
+			all = append(all, res)
+
+			vote, voted := res, true
+			switch errors.Cause(res.err) {
+			case nil:
+			case storage.ErrFuzzyMatchFound:
+				// Suggestions are only a guess, when voting they're the same as not having the short
+				suggestions = append(suggestions, storage.Suggestions(res.err))
+				vote = loadResult{"", storage.ErrShortNotSet}
+			case storage.ErrShortNotSet:
+				vote = loadResult{"", storage.ErrShortNotSet}
+			default:
+				voted = false
+			}
+
+			if voted {
+				if votes[vote]++; votes[vote] >= quorum {
+					switch {
+					case vote.err == nil:
+						recordVisit(ctx, stores[indexed.index], short)
+						return vote.long, nil
+					case len(suggestions) > 0:
+						return "", mergeSuggestions(suggestions)
+					default:
+						return "", storage.ErrShortNotSet
+					}
+				}
+			}
+
+			var leading int
+			for _, count := range votes {
+				leading = max(leading, count)
+			}
+			if leading+len(stores)-received < quorum {
+				break
+			}
+		}
+
+		return "", errors.Wrapf(ErrNoQuorum, "%#v", all)
+	}
+}
@@ -3,6 +3,7 @@ package multistorage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/thomasdesr/go-shorten/storage"
@@ -17,6 +18,13 @@ func inmemStorageFromMap(inputs map[string]string) *storage.Inmem {
 	return s
 }
 
+func fuzzyInmemStorageFromMap(inputs map[string]string) *storage.Inmem {
+	s := inmemStorageFromMap(inputs)
+	s.Fuzzy = storage.DefaultFuzzyMatcher
+
+	return s
+}
+
 func TestLoadFirstFunc(t *testing.T) {
 	inputs := []map[string]string{
 		{"a": "http://A"},
@@ -64,6 +72,26 @@ func TestLoadFirstFunc(t *testing.T) {
 			expectedLong: "",
 			expectedErr:  storage.ErrShortNotSet,
 		},
+		{ // A suggestion from an earlier store doesn't hide the real link in a later one
+			name: "FuzzyMatchThenExact",
+			stores: []storage.NamedStorage{
+				fuzzyInmemStorageFromMap(map[string]string{"docs": "http://docs"}),
+				inmemStorageFromMap(map[string]string{"doc": "http://doc"}),
+			},
+			inputShort:   "doc",
+			expectedLong: "http://doc",
+			expectedErr:  nil,
+		},
+		{ // But if nothing has it the suggestions are passed back
+			name: "FuzzyMatchOnly",
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(inputs[0]),
+				fuzzyInmemStorageFromMap(map[string]string{"docs": "http://docs"}),
+			},
+			inputShort:   "doc",
+			expectedLong: "",
+			expectedErr:  storage.ErrFuzzyMatchFound,
+		},
 		{ // Test an empty list
 			name:         "EmptyList",
 			stores:       []storage.NamedStorage{},
@@ -178,3 +206,242 @@ func TestLoadCompareAllResultsFunc(t *testing.T) {
 		})
 	}
 }
+
+// slowStorage waits for delay before loading from the underlying store, or until ctx is done if delay is 0
+type slowStorage struct {
+	storage.NamedStorage
+	delay time.Duration
+}
+
+func (s slowStorage) Load(ctx context.Context, short string) (string, error) {
+	var wait <-chan time.Time
+	if s.delay > 0 {
+		wait = time.After(s.delay)
+	}
+
+	select {
+	case <-wait:
+		return s.NamedStorage.Load(ctx, short)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+var errBrokenStorage = errors.New("broken storage")
+
+// brokenStorage fails every load
+type brokenStorage struct {
+	storage.NamedStorage
+}
+
+func (brokenStorage) Load(ctx context.Context, short string) (string, error) {
+	return "", errBrokenStorage
+}
+
+func TestLoadFirstParallelFunc(t *testing.T) {
+	storageTestTable := []struct {
+		name         string
+		stores       []storage.NamedStorage
+		inputShort   string
+		expectedLong string
+		expectedErr  error
+	}{
+		{ // The first store with the short wins, like LoadFirst
+			name: "Simple",
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"b": "http://B"}),
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				inmemStorageFromMap(map[string]string{"a": "http://C"}),
+			},
+			inputShort:   "a",
+			expectedLong: "http://A",
+		},
+		{ // A store that never answers shouldn't hold up the one before it
+			name: "DoesntWaitForLowerPriority",
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://C"}), 0},
+			},
+			inputShort:   "a",
+			expectedLong: "http://A",
+		},
+		{ // A slow store still beats a quicker one after it
+			name: "WaitsForHigherPriority",
+			stores: []storage.NamedStorage{
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://A"}), 20 * time.Millisecond},
+				inmemStorageFromMap(map[string]string{"a": "http://C"}),
+			},
+			inputShort:   "a",
+			expectedLong: "http://A",
+		},
+		{ // Errors are answers too
+			name: "Error",
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{}),
+				brokenStorage{inmemStorageFromMap(map[string]string{})},
+				inmemStorageFromMap(map[string]string{"a": "http://C"}),
+			},
+			inputShort:  "a",
+			expectedErr: errBrokenStorage,
+		},
+		{
+			name: "FuzzyMatchOnly",
+			stores: []storage.NamedStorage{
+				fuzzyInmemStorageFromMap(map[string]string{"grafana": "http://A"}),
+				inmemStorageFromMap(map[string]string{}),
+			},
+			inputShort:  "grafna",
+			expectedErr: storage.ErrFuzzyMatchFound,
+		},
+		{
+			name: "Missing",
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				inmemStorageFromMap(map[string]string{"b": "http://B"}),
+			},
+			inputShort:  "c",
+			expectedErr: storage.ErrShortNotSet,
+		},
+		{
+			name: "Cancelled",
+			stores: []storage.NamedStorage{
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://A"}), 0},
+			},
+			inputShort:  "a",
+			expectedErr: context.DeadlineExceeded,
+		},
+		{
+			name:        "EmptyList",
+			stores:      []storage.NamedStorage{},
+			inputShort:  "a",
+			expectedErr: ErrEmpty,
+		},
+	}
+
+	for _, tt := range storageTestTable {
+		tt := tt // Scoped copy
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			t.Logf("querying for %q, expecting (%q,%#v)", tt.inputShort, tt.expectedLong, tt.expectedErr)
+			long, err := loadFirstParallelFunc(ctx, tt.inputShort, tt.stores)
+			t.Logf("got: (%q, %#v)", long, err)
+			if cause := errors.Cause(err); cause != tt.expectedErr {
+				t.Errorf("unexpected error: expected(%#v) != actual(%#v)", tt.expectedErr, cause)
+			}
+
+			if long != tt.expectedLong {
+				t.Errorf("unexpected long: expected(%q) != actual(%q)", tt.expectedLong, long)
+			}
+		})
+	}
+}
+
+func TestLoadQuorumFunc(t *testing.T) {
+	storageTestTable := []struct {
+		name         string
+		quorum       int
+		stores       []storage.NamedStorage
+		inputShort   string
+		expectedLong string
+		expectedErr  error
+	}{
+		{
+			name:   "Majority",
+			quorum: 2,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://Other"}),
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+			},
+			inputShort:   "a",
+			expectedLong: "http://A",
+		},
+		{ // Once quorum is reached the stores that haven't answered don't matter
+			name:   "DoesntWaitOnceAgreed",
+			quorum: 2,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://Other"}), 0},
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+			},
+			inputShort:   "a",
+			expectedLong: "http://A",
+		},
+		{ // Agreeing that the short doesn't exist is still agreeing
+			name:   "AgreeMissing",
+			quorum: 2,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				inmemStorageFromMap(map[string]string{}),
+				fuzzyInmemStorageFromMap(map[string]string{"ab": "http://AB"}),
+			},
+			inputShort:  "a",
+			expectedErr: storage.ErrFuzzyMatchFound,
+		},
+		{
+			name:   "Disagree",
+			quorum: 2,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				inmemStorageFromMap(map[string]string{"a": "http://B"}),
+				inmemStorageFromMap(map[string]string{"a": "http://C"}),
+			},
+			inputShort:  "a",
+			expectedErr: ErrNoQuorum,
+		},
+		{ // Broken stores don't count, and once quorum is out of reach there's no point waiting on the slow store
+			name:   "TooManyBroken",
+			quorum: 3,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				brokenStorage{inmemStorageFromMap(map[string]string{"a": "http://A"})},
+				brokenStorage{inmemStorageFromMap(map[string]string{"a": "http://A"})},
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://A"}), time.Hour},
+			},
+			inputShort:  "a",
+			expectedErr: ErrNoQuorum,
+		},
+		{
+			name:   "Cancelled",
+			quorum: 2,
+			stores: []storage.NamedStorage{
+				inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				slowStorage{inmemStorageFromMap(map[string]string{"a": "http://A"}), 0},
+			},
+			inputShort:  "a",
+			expectedErr: context.DeadlineExceeded,
+		},
+		{
+			name:        "InvalidQuorum",
+			quorum:      3,
+			stores:      []storage.NamedStorage{inmemStorageFromMap(map[string]string{})},
+			inputShort:  "a",
+			expectedErr: ErrInvalidQuorum,
+		},
+	}
+
+	for _, tt := range storageTestTable {
+		tt := tt // Scoped copy
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			t.Logf("querying for %q with a quorum of %d, expecting (%q,%#v)", tt.inputShort, tt.quorum, tt.expectedLong, tt.expectedErr)
+			long, err := loadQuorumFunc(tt.quorum)(ctx, tt.inputShort, tt.stores)
+			t.Logf("got: (%q, %#v)", long, err)
+			if cause := errors.Cause(err); cause != tt.expectedErr {
+				t.Errorf("unexpected error: expected(%#v) != actual(%#v)", tt.expectedErr, cause)
+			}
+
+			if long != tt.expectedLong {
+				t.Errorf("unexpected long: expected(%q) != actual(%q)", tt.expectedLong, long)
+			}
+		})
+	}
+}
@@ -2,23 +2,28 @@ package multistorage
 
 import (
 	"context"
+	"sort"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/thomasdesr/go-shorten/storage"
 )
 
 // MultiStorage is a storage.NamedStorage that will allow you to interact with multiple underlying storage.NamedStorages.
 type MultiStorage struct {
-	stores []storage.NamedStorage
-	loader Loader
-	saver  Saver
+	stores     []storage.NamedStorage
+	readOnly   []bool // Whether each store is left alone when saving, deleting and repairing
+	loader     Loader
+	saver      Saver
+	readRepair bool
 }
 
 func New(stores []storage.NamedStorage, opts ...MultiStorageOption) (*MultiStorage, error) {
 	m := &MultiStorage{
-		stores: stores,
-		loader: loadFirstFunc,
-		saver:  saveAllFunc,
+		stores:   stores,
+		readOnly: make([]bool, len(stores)),
+		loader:   loadFirstFunc,
+		saver:    saveAllFunc,
 	}
 
 	for _, opt := range opts {
@@ -38,6 +43,11 @@ func Simple(stores ...storage.NamedStorage) (*MultiStorage, error) {
 	return New(stores, LoadFirst(), SaveToAll())
 }
 
+// Stores returns the underlying stores, in the order they were given to New
+func (s *MultiStorage) Stores() []storage.NamedStorage {
+	return append([]storage.NamedStorage(nil), s.stores...)
+}
+
 var ErrEmpty = errors.New("MultiStorage has no underlying stores")
 
 func (s *MultiStorage) validateStore() error {
@@ -48,13 +58,44 @@ func (s *MultiStorage) validateStore() error {
 	return nil
 }
 
+// writable returns the stores that links can be saved into, or storage.ErrReadOnly if there aren't any
+func (s *MultiStorage) writable() ([]storage.NamedStorage, error) {
+	stores := make([]storage.NamedStorage, 0, len(s.stores))
+	for i, store := range s.stores {
+		if !s.readOnly[i] {
+			stores = append(stores, store)
+		}
+	}
+
+	if len(stores) == 0 {
+		return nil, storage.ErrReadOnly
+	}
+	return stores, nil
+}
+
 // Load with a basic MultiStorage will query the underlying storages (in order) returning when either a response or error is encountered, only returning an ErrShortNotSet when all underlying storages have been exhausted.
 func (s *MultiStorage) Load(ctx context.Context, short string) (string, error) {
 	if err := s.validateStore(); err != nil {
 		return "", errors.Wrap(err, "failed to validate underlying store")
 	}
 
-	return s.loader(ctx, short, s.stores)
+	if !s.readRepair {
+		return s.loader(ctx, short, s.stores)
+	}
+
+	recorders := make([]*recordingStorage, len(s.stores))
+	stores := make([]storage.NamedStorage, len(s.stores))
+	for i, store := range s.stores {
+		recorders[i] = &recordingStorage{NamedStorage: store}
+		stores[i] = recorders[i]
+	}
+
+	long, err := s.loader(ctx, short, stores)
+	if err == nil {
+		s.repair(ctx, short, long, recorders)
+	}
+
+	return long, err
 }
 
 // SaveName will return the first successful insure that all
@@ -63,5 +104,425 @@ func (s *MultiStorage) SaveName(ctx context.Context, short string, long string)
 		return errors.Wrap(err, "failed to validate underlying store")
 	}
 
-	return s.saver(ctx, short, long, s.stores)
+	stores, err := s.writable()
+	if err != nil {
+		return err
+	}
+
+	return s.saver(ctx, short, long, stores)
+}
+
+// ValidateLink runs the configured Saver over the writable stores, with each of them only checking whether it would
+// accept the link, so a link is valid if saving it would succeed
+func (s *MultiStorage) ValidateLink(short string, url string) error {
+	if err := s.validateStore(); err != nil {
+		return errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	stores, err := s.writable()
+	if err != nil {
+		return err
+	}
+
+	validators := make([]storage.NamedStorage, len(stores))
+	for i, store := range stores {
+		validators[i] = validatingStorage{store}
+	}
+
+	return s.saver(context.Background(), short, url, validators)
+}
+
+// validatingStorage checks links with storage.ValidateLink instead of saving them
+type validatingStorage struct {
+	storage.NamedStorage
+}
+
+func (v validatingStorage) SaveName(ctx context.Context, short string, url string) error {
+	return storage.ValidateLink(v.NamedStorage, short, url)
+}
+
+// CanonicalShort returns the form short is saved under by the first writable store that changes shorts when saving
+// them, or short unchanged if none do
+func (s *MultiStorage) CanonicalShort(short string) (string, error) {
+	stores, err := s.writable()
+	if err != nil {
+		return "", err
+	}
+
+	for _, store := range stores {
+		if cs, ok := store.(storage.CanonicalStorage); ok {
+			return cs.CanonicalShort(short)
+		}
+	}
+
+	return short, nil
+}
+
+// Save generates a short that none of the underlying stores resolve, then saves url under it with the configured Saver
+func (s *MultiStorage) Save(ctx context.Context, url string) (string, error) {
+	if err := s.validateStore(); err != nil {
+		return "", errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	stores, err := s.writable()
+	if err != nil {
+		return "", err
+	}
+
+	return storage.GenerateShort(s.generatedLength(stores), func(short string) (bool, error) {
+		_, err := s.loader(ctx, short, s.stores)
+		switch errors.Cause(err) {
+		case storage.ErrShortNotSet, storage.ErrFuzzyMatchFound:
+			// Nobody has it, it's ours
+		case nil, ErrUnexpectedMultipleAnswers, ErrNoQuorum:
+			return false, nil
+		default:
+			return false, err
+		}
+
+		return true, s.saver(ctx, short, url, stores)
+	})
+}
+
+// GeneratedLength returns how many characters the shorts Save generates have, see generatedLength
+func (s *MultiStorage) GeneratedLength() int {
+	stores, err := s.writable()
+	if err != nil {
+		return storage.DefaultRandLength
+	}
+
+	return s.generatedLength(stores)
+}
+
+// generatedLength is the length of the shorts that the first of stores that generates shorts itself would generate
+func (s *MultiStorage) generatedLength(stores []storage.NamedStorage) int {
+	for _, store := range stores {
+		if gs, ok := store.(storage.GeneratingStorage); ok {
+			return gs.GeneratedLength()
+		}
+	}
+
+	return storage.DefaultRandLength
+}
+
+// Delete removes the short from every underlying store that supports deletion, only returning ErrShortNotSet when none of them had it
+func (s *MultiStorage) Delete(ctx context.Context, short string) error {
+	if err := s.validateStore(); err != nil {
+		return errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	var found bool
+	errs := new(multierror.Error)
+	for i, store := range s.stores {
+		deletable, ok := store.(storage.DeletableStorage)
+		if !ok || s.readOnly[i] {
+			continue
+		}
+
+		switch err := deletable.Delete(ctx, short); errors.Cause(err) {
+		case nil:
+			found = true
+		case storage.ErrShortNotSet:
+		default:
+			multierror.Append(
+				errs,
+				errors.Wrapf(err, "failed to delete %q from %q", short, store),
+			)
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return err
+	}
+	if !found {
+		return storage.ErrShortNotSet
+	}
+
+	return nil
+}
+
+// RecordVisit counts a visit to short in the first store that counts visits and has the short. Stores that count
+// visits without checking they have the short, like Postgres, take any visit that gets to them.
+func (s *MultiStorage) RecordVisit(ctx context.Context, short string) error {
+	if err := s.validateStore(); err != nil {
+		return errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	errs := new(multierror.Error)
+	for _, store := range s.stores {
+		vs, ok := store.(storage.VisitStorage)
+		if !ok {
+			continue
+		}
+
+		switch err := vs.RecordVisit(ctx, short); errors.Cause(err) {
+		case nil:
+			return nil
+		case storage.ErrShortNotSet:
+		default:
+			multierror.Append(
+				errs,
+				errors.Wrapf(err, "failed to record a visit to %q in %q", short, store),
+			)
+		}
+	}
+
+	if err := errs.ErrorOrNil(); err != nil {
+		return err
+	}
+	return storage.ErrShortNotSet
+}
+
+// Reload reloads every underlying store that supports it
+func (s *MultiStorage) Reload(ctx context.Context) error {
+	errs := new(multierror.Error)
+	for _, store := range s.stores {
+		reloadable, ok := store.(storage.ReloadableStorage)
+		if !ok {
+			continue
+		}
+
+		if err := reloadable.Reload(ctx); err != nil {
+			multierror.Append(
+				errs,
+				errors.Wrapf(err, "failed to reload %q", store),
+			)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// Close closes every underlying store that needs closing
+func (s *MultiStorage) Close() error {
+	errs := new(multierror.Error)
+	for _, store := range s.stores {
+		closable, ok := store.(storage.ClosableStorage)
+		if !ok {
+			continue
+		}
+
+		if err := closable.Close(); err != nil {
+			multierror.Append(
+				errs,
+				errors.Wrapf(err, "failed to close %q", store),
+			)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// Metadata returns the metadata from the first underlying store that has the short
+func (s *MultiStorage) Metadata(ctx context.Context, short string) (storage.LinkMetadata, error) {
+	if err := s.validateStore(); err != nil {
+		return storage.LinkMetadata{}, errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	for _, store := range s.stores {
+		ms, ok := store.(storage.MetadataStorage)
+		if !ok {
+			continue
+		}
+
+		md, err := ms.Metadata(ctx, short)
+		if errors.Cause(err) == storage.ErrShortNotSet {
+			continue
+		}
+
+		return md, err
+	}
+
+	return storage.LinkMetadata{}, storage.ErrShortNotSet
+}
+
+// Supports returns whether any of the underlying stores fits, since each of the optional interfaces MultiStorage
+// implements is only backed by the stores that implement it too
+func (s *MultiStorage) Supports(fits func(storage.Storage) bool) bool {
+	for _, store := range s.stores {
+		if fits(store) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// History returns the history from the first underlying store that has any for the short
+func (s *MultiStorage) History(ctx context.Context, short string) ([]storage.HistoryEntry, error) {
+	if err := s.validateStore(); err != nil {
+		return nil, errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	for _, store := range s.stores {
+		hs, ok := store.(storage.HistoryStorage)
+		if !ok {
+			continue
+		}
+
+		history, err := hs.History(ctx, short)
+		if errors.Cause(err) == storage.ErrShortNotSet {
+			continue
+		}
+
+		return history, err
+	}
+
+	return nil, storage.ErrShortNotSet
+}
+
+// Revert looks the revision up in the short's History and saves its URL back through the configured Saver
+func (s *MultiStorage) Revert(ctx context.Context, short string, revision string) error {
+	history, err := s.History(ctx, short)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range history {
+		if entry.Revision == revision {
+			return s.SaveName(ctx, short, entry.URL)
+		}
+	}
+
+	return storage.ErrRevisionNotFound
+}
+
+// List merges the pages of every underlying store that supports listing. When more than one store has the same short
+// the earlier store's URL wins, matching LoadFirst.
+func (s *MultiStorage) List(ctx context.Context, cursor string, limit int) ([]storage.ListResult, string, error) {
+	if err := s.validateStore(); err != nil {
+		return nil, "", errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	// Every child returns its first `limit` shorts after cursor, which always contains the merged first `limit`
+	seen := make(map[string]bool)
+	var (
+		merged []storage.ListResult
+		more   bool
+	)
+	for _, store := range s.stores {
+		ls, ok := store.(storage.ListableStorage)
+		if !ok {
+			continue
+		}
+
+		results, next, err := ls.List(ctx, cursor, limit)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "failed to list %q", store)
+		}
+		more = more || next != ""
+
+		for _, result := range results {
+			if seen[result.Link] {
+				continue
+			}
+			seen[result.Link] = true
+			merged = append(merged, result)
+		}
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Link < merged[j].Link
+	})
+
+	if limit <= 0 || len(merged) < limit || (len(merged) == limit && !more) {
+		return merged, "", nil
+	}
+
+	merged = merged[:limit]
+	return merged, merged[limit-1].Link, nil
+}
+
+// Search merges the results of every underlying store that supports searching. Stores don't say how relevant their
+// results are, so the merged results take each store's best result, then each store's second best and so on. When
+// more than one store has the same short the earlier store's URL wins, matching LoadFirst.
+func (s *MultiStorage) Search(ctx context.Context, searchTerm string) ([]storage.SearchResult, error) {
+	if err := s.validateStore(); err != nil {
+		return nil, errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	var ranked [][]storage.SearchResult
+	for _, store := range s.stores {
+		ss, ok := store.(storage.SearchableStorage)
+		if !ok {
+			continue
+		}
+
+		results, err := ss.Search(ctx, searchTerm)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to search %q", store)
+		}
+		ranked = append(ranked, results)
+	}
+
+	// Shorts from earlier stores are claimed first so their URLs win wherever the short is ranked
+	urls := make(map[string]string)
+	for _, results := range ranked {
+		for _, result := range results {
+			if _, ok := urls[result.Link]; !ok {
+				urls[result.Link] = result.URL
+			}
+		}
+	}
+
+	var merged []storage.SearchResult
+	for rank := 0; len(urls) > 0; rank++ {
+		for _, results := range ranked {
+			if rank >= len(results) {
+				continue
+			}
+
+			url, ok := urls[results[rank].Link]
+			if !ok {
+				continue
+			}
+			delete(urls, results[rank].Link)
+			merged = append(merged, storage.SearchResult{Link: results[rank].Link, URL: url})
+		}
+	}
+
+	return merged, nil
+}
+
+// TopNForPeriod adds up the visits each underlying store that supports it recorded for a short. Each store only
+// returns its own top n, so a short that's popular overall but just misses the top n in some stores is undercounted.
+func (s *MultiStorage) TopNForPeriod(ctx context.Context, n int, days int) ([]storage.TopNResult, error) {
+	if err := s.validateStore(); err != nil {
+		return nil, errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	hits := make(map[string]int)
+	for _, store := range s.stores {
+		tns, ok := store.(storage.TopN)
+		if !ok {
+			continue
+		}
+
+		results, err := tns.TopNForPeriod(ctx, n, days)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get the top shorts from %q", store)
+		}
+
+		for _, result := range results {
+			hits[result.Link] += result.HitCount
+		}
+	}
+
+	merged := make([]storage.TopNResult, 0, len(hits))
+	for short, count := range hits {
+		merged = append(merged, storage.TopNResult{Link: short, HitCount: count})
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].HitCount != merged[j].HitCount {
+			return merged[i].HitCount > merged[j].HitCount
+		}
+		return merged[i].Link < merged[j].Link
+	})
+
+	if n >= 0 && len(merged) > n {
+		merged = merged[:n]
+	}
+
+	return merged, nil
 }
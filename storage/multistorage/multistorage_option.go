@@ -1,5 +1,7 @@
 package multistorage
 
+import "github.com/pkg/errors"
+
 // MultiStorageOptions allows you to to configure out the MultiStorage will behave. For example should it Save changes to all underlying packages, or just the first one.
 type MultiStorageOption func(*MultiStorage) error
 
@@ -19,6 +21,26 @@ func LoadCompareAllResults() MultiStorageOption {
 	}
 }
 
+// LoadFirstParallel causes the MultiStorage to load the short from all of the underlying stores at the same time, returning the same answer as LoadFirst but without waiting on stores after the one that answers
+func LoadFirstParallel() MultiStorageOption {
+	return func(m *MultiStorage) error {
+		m.loader = loadFirstParallelFunc
+		return nil
+	}
+}
+
+// LoadQuorum causes the MultiStorage to load the short from all of the underlying stores at the same time, returning as soon as quorum of them agree. If they can't agree it will return ErrNoQuorum
+func LoadQuorum(quorum int) MultiStorageOption {
+	return func(m *MultiStorage) error {
+		if quorum < 1 || quorum > len(m.stores) {
+			return ErrInvalidQuorum
+		}
+
+		m.loader = loadQuorumFunc(quorum)
+		return nil
+	}
+}
+
 // SaveToAll causes the MultiStorage to try to save the short and url to all of the underlying stores. Any/all errors will be returned together
 func SaveToAll() MultiStorageOption {
 	return func(m *MultiStorage) error {
@@ -34,3 +56,26 @@ func SaveOnlyOnce() MultiStorageOption {
 		return nil
 	}
 }
+
+// ReadRepair causes the MultiStorage to save a short it loaded into the stores before the one it was found in that didn't have it, so they have it next time
+func ReadRepair() MultiStorageOption {
+	return func(m *MultiStorage) error {
+		m.readRepair = true
+		return nil
+	}
+}
+
+var ErrInvalidStoreIndex = errors.New("MultiStorage: no store at that index")
+
+// ReadOnly causes the MultiStorage to only load from the stores at the given indexes, never saving, deleting or repairing links in them
+func ReadOnly(indexes ...int) MultiStorageOption {
+	return func(m *MultiStorage) error {
+		for _, i := range indexes {
+			if i < 0 || i >= len(m.stores) {
+				return errors.Wrapf(ErrInvalidStoreIndex, "%d", i)
+			}
+			m.readOnly[i] = true
+		}
+		return nil
+	}
+}
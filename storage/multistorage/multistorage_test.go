@@ -2,9 +2,12 @@ package multistorage_test
 
 import (
 	"context"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 	"github.com/thomasdesr/go-shorten/storage"
 	"github.com/thomasdesr/go-shorten/storage/multistorage"
 )
@@ -91,6 +94,387 @@ func TestMultipleBackendLoad(t *testing.T) {
 	}
 }
 
+func TestMultipleBackendSave(t *testing.T) {
+	inputLong := "http://generated"
+
+	m, err := multistorage.Simple(
+		inmemStorageFromMap(map[string]string{}),
+		inmemStorageFromMap(map[string]string{}),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	short, err := m.Save(context.Background(), inputLong)
+	t.Logf("Got: %q, %v", short, err)
+	if err != nil {
+		t.Fatalf("error saving %q into the store: %q", inputLong, err)
+	}
+
+	long, err := m.Load(context.Background(), short)
+	if err != nil {
+		t.Fatalf("error loading generated short %q: %q", short, err)
+	}
+
+	if long != inputLong {
+		t.Fatalf("returned incorrect value from the underlying stores: %q != %q", long, inputLong)
+	}
+}
+
+func TestMultipleBackendSaveLength(t *testing.T) {
+	short4, err := storage.NewInmem(4)
+	if err != nil {
+		t.Fatal("failed creating inmem storage", err)
+	}
+	short12, err := storage.NewInmem(12)
+	if err != nil {
+		t.Fatal("failed creating inmem storage", err)
+	}
+
+	m, err := multistorage.Simple(short12, short4)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	assert.Equal(t, 12, m.GeneratedLength(), "the first store's length should be used")
+
+	short, err := m.Save(context.Background(), "http://generated")
+	if err != nil {
+		t.Fatalf("error saving into the store: %q", err)
+	}
+	assert.Len(t, short, 12)
+	assert.Regexp(t, "^[a-z0-9]+$", short)
+}
+
+func TestMultipleBackendDelete(t *testing.T) {
+	m, err := multistorage.Simple(
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+		inmemStorageFromMap(map[string]string{"b": "http://B"}),
+		inmemStorageFromMap(map[string]string{"a": "http://C"}),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	if err := m.Delete(context.Background(), "a"); err != nil {
+		t.Fatalf("error deleting %q: %q", "a", err)
+	}
+
+	if _, err := m.Load(context.Background(), "a"); err != storage.ErrShortNotSet {
+		t.Errorf("%q should've been deleted from all stores, got: %v", "a", err)
+	}
+
+	if err := m.Delete(context.Background(), "a"); errors.Cause(err) != storage.ErrShortNotSet {
+		t.Errorf("deleting a missing short should return ErrShortNotSet, got: %v", err)
+	}
+
+	if long, err := m.Load(context.Background(), "b"); err != nil || long != "http://B" {
+		t.Errorf("unrelated short was affected by delete: %q, %v", long, err)
+	}
+}
+
+func TestMultipleBackendList(t *testing.T) {
+	m, err := multistorage.Simple(
+		inmemStorageFromMap(map[string]string{"a": "http://A", "c": "http://C"}),
+		inmemStorageFromMap(map[string]string{"b": "http://B", "c": "http://Other"}),
+		inmemStorageFromMap(map[string]string{"d": "http://D"}),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	expected := []storage.ListResult{
+		{Link: "a", URL: "http://A"},
+		{Link: "b", URL: "http://B"},
+		{Link: "c", URL: "http://C"},
+		{Link: "d", URL: "http://D"},
+	}
+
+	var (
+		listed []storage.ListResult
+		cursor string
+	)
+	for {
+		page, next, err := m.List(context.Background(), cursor, 3)
+		t.Logf("Got: %v, %q, %v", page, next, err)
+		if err != nil {
+			t.Fatalf("error listing after %q: %q", cursor, err)
+		}
+
+		listed = append(listed, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	assert.Equal(t, expected, listed)
+}
+
+func TestMultipleBackendSearch(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{"grafana-(.+)": "http://grafana/$1"})
+	if err != nil {
+		t.Fatal("failed creating regex storage", err)
+	}
+
+	m, err := multistorage.Simple(
+		inmemStorageFromMap(map[string]string{"grafana": "http://A", "docs": "http://docs"}),
+		regex, // Can't be searched, so it's skipped
+		inmemStorageFromMap(map[string]string{"grafana": "http://Other"}),
+		inmemStorageFromMap(map[string]string{"grafanalogs": "http://logs"}),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	results, err := m.Search(context.Background(), "grafana")
+	t.Logf("Got: %v, %v", results, err)
+	if err != nil {
+		t.Fatalf("error searching: %q", err)
+	}
+
+	assert.Equal(t, []storage.SearchResult{
+		{Link: "grafana", URL: "http://A"},
+		{Link: "grafanalogs", URL: "http://logs"},
+	}, results)
+}
+
+func TestMultipleBackendTopN(t *testing.T) {
+	stores := []*storage.Inmem{
+		inmemStorageFromMap(map[string]string{"a": "http://A", "b": "http://B"}),
+		inmemStorageFromMap(map[string]string{"a": "http://A", "c": "http://C"}),
+	}
+	visits := []map[string]int{
+		{"a": 2, "b": 1},
+		{"a": 1, "c": 2},
+	}
+	for i, store := range stores {
+		for short, count := range visits[i] {
+			for j := 0; j < count; j++ {
+				if _, err := store.Load(context.Background(), short); err != nil {
+					t.Fatalf("error visiting %q: %q", short, err)
+				}
+			}
+		}
+	}
+
+	m, err := multistorage.Simple(stores[0], stores[1])
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	results, err := m.TopNForPeriod(context.Background(), 2, 1)
+	t.Logf("Got: %v, %v", results, err)
+	if err != nil {
+		t.Fatalf("error getting top shorts: %q", err)
+	}
+
+	assert.Equal(t, []storage.TopNResult{
+		{Link: "a", HitCount: 3},
+		{Link: "c", HitCount: 2},
+	}, results)
+}
+
+func TestReadRepair(t *testing.T) {
+	for name, loader := range map[string]multistorage.MultiStorageOption{
+		"LoadFirst":         multistorage.LoadFirst(),
+		"LoadFirstParallel": multistorage.LoadFirstParallel(),
+	} {
+		loader := loader
+		t.Run(name, func(t *testing.T) {
+			stores := []*storage.Inmem{
+				inmemStorageFromMap(map[string]string{}),
+				inmemStorageFromMap(map[string]string{}),
+				inmemStorageFromMap(map[string]string{"a": "http://A", "pr/{id}": "http://pulls/{id}"}),
+				inmemStorageFromMap(map[string]string{}),
+			}
+
+			m, err := multistorage.New(
+				[]storage.NamedStorage{stores[0], stores[1], stores[2], stores[3]},
+				loader, multistorage.SaveToAll(), multistorage.ReadRepair(),
+			)
+			if err != nil {
+				t.Fatal("failed creating multistorage", err)
+			}
+
+			long, err := m.Load(context.Background(), "a")
+			assert.Nil(t, err)
+			assert.Equal(t, "http://A", long)
+
+			for i, expected := range map[int]error{0: nil, 1: nil, 3: storage.ErrShortNotSet} {
+				long, err := stores[i].LoadExact(context.Background(), "a")
+				t.Logf("store %d: %q, %v", i, long, err)
+				assert.Equal(t, expected, err, "only the stores before the one with the link should be repaired")
+			}
+
+			// A parameterized link isn't saved as every short it resolves
+			long, err = m.Load(context.Background(), "pr/1")
+			assert.Nil(t, err)
+			assert.Equal(t, "http://pulls/1", long)
+
+			_, err = stores[0].LoadExact(context.Background(), "pr/1")
+			assert.Equal(t, storage.ErrShortNotSet, err)
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{"jira/(.+)": "http://jira/$1"})
+	if err != nil {
+		t.Fatal("failed creating regex storage", err)
+	}
+
+	stores := []*storage.Inmem{
+		inmemStorageFromMap(map[string]string{"a": "http://A", "c": "http://C"}),
+		inmemStorageFromMap(map[string]string{"b": "http://B", "c": "http://Other"}),
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+	}
+
+	m, err := multistorage.New(
+		[]storage.NamedStorage{stores[0], regex, stores[1], stores[2]},
+		multistorage.LoadFirst(), multistorage.SaveOnlyOnce(),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	report, err := m.Sync(context.Background())
+	t.Logf("Got: %#v, %v", report, err)
+	if err != nil {
+		t.Fatalf("error syncing: %q", err)
+	}
+
+	assert.Equal(t, multistorage.SyncReport{
+		Copied: 3, // a into the second inmem, and b into the other two
+		Conflicts: []multistorage.Conflict{
+			{Short: "c", URLs: []string{"http://C", "", "http://Other", ""}},
+		},
+	}, report)
+
+	for _, store := range stores {
+		for short, expected := range map[string]string{"a": "http://A", "b": "http://B"} {
+			long, err := store.LoadExact(context.Background(), short)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, long)
+		}
+	}
+
+	_, err = stores[2].LoadExact(context.Background(), "c")
+	assert.Equal(t, storage.ErrShortNotSet, err, "conflicting shorts shouldn't be copied")
+
+	// Once synced there's nothing left to do
+	report, err = m.Sync(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Copied)
+}
+
+func TestSupports(t *testing.T) {
+	regex, err := storage.NewRegexFromList(map[string]string{"jira/(.+)": "http://jira/$1"})
+	if err != nil {
+		t.Fatal("failed creating regex storage", err)
+	}
+
+	withoutInmem, err := multistorage.Simple(regex, rawStorage{})
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+	withInmem, err := multistorage.Simple(regex, inmemStorageFromMap(map[string]string{}))
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+	nested, err := multistorage.Simple(withInmem)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	testTable := []struct {
+		name      string
+		store     storage.Storage
+		supported func(storage.Storage) bool
+		expected  bool
+	}{
+		{"search without inmem", withoutInmem, supports[storage.SearchableStorage], false},
+		{"top n without inmem", withoutInmem, supports[storage.TopN], false},
+		{"list without inmem", withoutInmem, supports[storage.ListableStorage], true},
+		{"delete without inmem", withoutInmem, supports[storage.DeletableStorage], true},
+		{"search with inmem", withInmem, supports[storage.SearchableStorage], true},
+		{"top n with inmem", withInmem, supports[storage.TopN], true},
+		{"top n nested", nested, supports[storage.TopN], true},
+		{"history nested", nested, supports[storage.HistoryStorage], false},
+		{"top n of a store that isn't a multistorage", regex, supports[storage.TopN], false},
+	}
+
+	for _, tt := range testTable {
+		assert.Equal(t, tt.expected, tt.supported(tt.store), tt.name)
+	}
+}
+
+func supports[T storage.Storage](store storage.Storage) bool {
+	_, ok := storage.Supports[T](store)
+	return ok
+}
+
+// rawStorage keeps shorts exactly as they're given, like Postgres does
+type rawStorage map[string]string
+
+func (s rawStorage) Load(ctx context.Context, short string) (string, error) {
+	return s.LoadExact(ctx, short)
+}
+
+func (s rawStorage) LoadExact(ctx context.Context, short string) (string, error) {
+	long, ok := s[short]
+	if !ok {
+		return "", storage.ErrShortNotSet
+	}
+	return long, nil
+}
+
+func (s rawStorage) SaveName(ctx context.Context, short string, long string) error {
+	s[short] = long
+	return nil
+}
+
+func (s rawStorage) CanonicalShort(short string) (string, error) { return short, nil }
+
+func (s rawStorage) List(ctx context.Context, cursor string, limit int) ([]storage.ListResult, string, error) {
+	var results []storage.ListResult
+	for short, long := range s {
+		results = append(results, storage.ListResult{Link: short, URL: long})
+	}
+	sort.Slice(results, func(a, b int) bool { return results[a].Link < results[b].Link })
+
+	return results, "", nil
+}
+
+func TestSyncCanonicalShorts(t *testing.T) {
+	raw := rawStorage{"Foo-Bar": "http://A", "Same-Link": "http://S", "New-Link": "http://N"}
+	inmem := inmemStorageFromMap(map[string]string{"foobar": "http://B", "samelink": "http://S"})
+
+	m, err := multistorage.Simple(raw, inmem)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	report, err := m.Sync(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, multistorage.SyncReport{
+		Copied:    1, // New-Link into the inmem
+		Conflicts: []multistorage.Conflict{{Short: "foobar", URLs: []string{"http://A", "http://B"}}},
+	}, report)
+
+	long, err := inmem.LoadExact(context.Background(), "newlink")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://N", long)
+	long, err = inmem.LoadExact(context.Background(), "foobar")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://B", long, "conflicting shorts shouldn't be overwritten")
+	assert.Len(t, raw, 3, "shorts the stores both have shouldn't be copied back")
+
+	report, err = m.Sync(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Copied, "links copied under their canonical short shouldn't be copied again")
+}
+
 // func TestQuickSingleBackend(t *testing.T) {
 // 	f := func(shortens map[string]string) bool {
 // 		m, err := multistorage.New(
@@ -119,3 +503,164 @@ func TestMultipleBackendLoad(t *testing.T) {
 // 		t.Error(err)
 // 	}
 // }
+
+func TestReadOnly(t *testing.T) {
+	stores := []*storage.Inmem{
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+	}
+
+	m, err := multistorage.New(
+		[]storage.NamedStorage{stores[0], stores[1]},
+		multistorage.LoadFirst(), multistorage.SaveToAll(), multistorage.ReadOnly(0),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	assert.Nil(t, m.SaveName(context.Background(), "b", "http://B"))
+	_, err = stores[0].LoadExact(context.Background(), "b")
+	assert.Equal(t, storage.ErrShortNotSet, err, "read-only stores shouldn't be saved into")
+	long, err := stores[1].LoadExact(context.Background(), "b")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://B", long)
+
+	assert.Nil(t, m.Delete(context.Background(), "a"))
+	long, err = stores[0].LoadExact(context.Background(), "a")
+	assert.Nil(t, err, "read-only stores shouldn't be deleted from")
+	assert.Equal(t, "http://A", long)
+
+	m, err = multistorage.New(
+		[]storage.NamedStorage{stores[0], stores[1]},
+		multistorage.ReadOnly(0, 1),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	assert.Equal(t, storage.ErrReadOnly, m.SaveName(context.Background(), "c", "http://C"))
+	_, err = m.Save(context.Background(), "http://C")
+	assert.Equal(t, storage.ErrReadOnly, err)
+
+	_, err = multistorage.New([]storage.NamedStorage{stores[0]}, multistorage.ReadOnly(1))
+	assert.Equal(t, multistorage.ErrInvalidStoreIndex, errors.Cause(err))
+}
+
+func TestLoaderVisits(t *testing.T) {
+	for name, loader := range map[string]multistorage.MultiStorageOption{
+		"LoadFirst":             multistorage.LoadFirst(),
+		"LoadFirstParallel":     multistorage.LoadFirstParallel(),
+		"LoadCompareAllResults": multistorage.LoadCompareAllResults(),
+		"LoadQuorum":            multistorage.LoadQuorum(2),
+	} {
+		loader := loader
+		t.Run(name, func(t *testing.T) {
+			m, err := multistorage.New(
+				[]storage.NamedStorage{
+					inmemStorageFromMap(map[string]string{"a": "http://A"}),
+					inmemStorageFromMap(map[string]string{"a": "http://A"}),
+				},
+				loader, multistorage.ReadRepair(),
+			)
+			if err != nil {
+				t.Fatal("failed creating multistorage", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				long, err := m.Load(context.Background(), "a")
+				assert.Nil(t, err)
+				assert.Equal(t, "http://A", long)
+			}
+
+			// Looking a link up isn't a visit
+			_, err = m.Load(storage.WithoutVisit(context.Background()), "a")
+			assert.Nil(t, err)
+
+			top, err := m.TopNForPeriod(context.Background(), 10, 0)
+			assert.Nil(t, err)
+			assert.Equal(t, []storage.TopNResult{{Link: "a", HitCount: 3}}, top, "each visit should be counted by one store")
+		})
+	}
+}
+
+// historyInmem is an Inmem that keeps a single revision of each link, for testing stores with history. It can't
+// revert, MultiStorage reverts by saving the revision's URL itself.
+type historyInmem struct {
+	*storage.Inmem
+}
+
+func (s historyInmem) History(ctx context.Context, short string) ([]storage.HistoryEntry, error) {
+	long, err := s.LoadExact(ctx, short)
+	if err != nil {
+		return nil, err
+	}
+
+	return []storage.HistoryEntry{{Revision: "1", URL: long}}, nil
+}
+
+func (s historyInmem) Revert(ctx context.Context, short string, revision string) error {
+	return storage.ErrNotSupported
+}
+
+func TestHistory(t *testing.T) {
+	m, err := multistorage.Simple(
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+		inmemStorageFromMap(map[string]string{}),
+	)
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	_, ok := storage.Supports[storage.HistoryStorage](m)
+	assert.False(t, ok, "history shouldn't be offered when no store keeps any")
+	_, ok = storage.Supports[storage.RevertableStorage](m)
+	assert.False(t, ok, "reverting shouldn't be offered when no store keeps history")
+
+	stores := []*storage.Inmem{
+		inmemStorageFromMap(map[string]string{}),
+		inmemStorageFromMap(map[string]string{"a": "http://A"}),
+	}
+	m, err = multistorage.Simple(stores[0], historyInmem{stores[1]})
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	rs, ok := storage.Supports[storage.RevertableStorage](m)
+	if !assert.True(t, ok, "reverting should be offered when a store keeps history") {
+		return
+	}
+
+	history, err := rs.History(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.Equal(t, []storage.HistoryEntry{{Revision: "1", URL: "http://A"}}, history)
+
+	assert.Nil(t, rs.Revert(context.Background(), "a", "1"))
+	assert.Equal(t, storage.ErrRevisionNotFound, rs.Revert(context.Background(), "a", "2"))
+	long, err := stores[0].LoadExact(context.Background(), "a")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://A", long, "reverting should save the revision's URL to every store")
+}
+
+func TestValidateLink(t *testing.T) {
+	regex, err := storage.NewRegexFromFile(filepath.Join(t.TempDir(), "remaps.yaml"))
+	if err != nil {
+		t.Fatal("failed creating regex storage", err)
+	}
+	stores := []storage.NamedStorage{regex, inmemStorageFromMap(map[string]string{})}
+
+	all, err := multistorage.New(stores, multistorage.SaveToAll())
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+	once, err := multistorage.New(stores, multistorage.SaveOnlyOnce())
+	if err != nil {
+		t.Fatal("failed creating multistorage", err)
+	}
+
+	assert.Nil(t, storage.ValidateLink(all, "docs", "http://docs"))
+	assert.NotNil(t, storage.ValidateLink(all, "c++", "http://cplusplus"), "the regex store can't save c++")
+	assert.Nil(t, storage.ValidateLink(once, "c++", "http://cplusplus"), "the inmem store can save c++")
+	assert.NotNil(t, storage.ValidateLink(once, "docs", "/not/absolute"))
+
+	assert.Empty(t, regex.Remaps(), "validating shouldn't save anything")
+}
@@ -0,0 +1,130 @@
+package multistorage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+var repairs = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: "multistorage",
+		Name:      "repairs_total",
+		Help:      "A counter for links copied into stores that were missing them, by whether a load (read) or Sync (sync) found them missing",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	prometheus.MustRegister(repairs, syncConflicts)
+}
+
+// recordingStorage remembers what Load returned, so read repair can tell which stores missed
+type recordingStorage struct {
+	storage.NamedStorage
+
+	result *loadResult
+	mu     sync.Mutex
+}
+
+func (r *recordingStorage) Load(ctx context.Context, short string) (string, error) {
+	long, err := r.NamedStorage.Load(ctx, short)
+
+	r.mu.Lock()
+	r.result = &loadResult{long, err}
+	r.mu.Unlock()
+
+	return long, err
+}
+
+// RecordVisit passes visits on to the store, since recordVisit can't see that it counts them through the wrapper
+func (r *recordingStorage) RecordVisit(ctx context.Context, short string) error {
+	vs, ok := r.NamedStorage.(storage.VisitStorage)
+	if !ok {
+		return nil
+	}
+
+	return vs.RecordVisit(ctx, short)
+}
+
+// loaded returns what Load returned, or false if it hasn't returned (or wasn't called)
+func (r *recordingStorage) loaded() (loadResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.result == nil {
+		return loadResult{}, false
+	}
+	return *r.result, true
+}
+
+func (r *recordingStorage) String() string {
+	return fmt.Sprint(r.NamedStorage)
+}
+
+// repair saves short into the stores before the first store that loaded long which didn't have it. Only links the
+// store actually has saved are copied, not ones it resolved from a parameterized link or a regex.
+func (s *MultiStorage) repair(ctx context.Context, short string, long string, recorders []*recordingStorage) {
+	source := -1
+	for i, recorder := range recorders {
+		if res, ok := recorder.loaded(); ok && res.err == nil && res.long == long {
+			source = i
+			break
+		}
+	}
+	if source <= 0 {
+		return
+	}
+
+	exact, ok := s.stores[source].(storage.ExactStorage)
+	if !ok {
+		return
+	}
+	if saved, err := exact.LoadExact(ctx, short); err != nil || saved != long {
+		return
+	}
+
+	saveCtx := editorContext(ctx, s.stores[source], short)
+	for i, recorder := range recorders[:source] {
+		if s.readOnly[i] {
+			continue
+		}
+
+		res, ok := recorder.loaded()
+		if !ok || !isMiss(res.err) {
+			continue
+		}
+
+		if err := recorder.NamedStorage.SaveName(saveCtx, short, long); err != nil {
+			log.Printf("Failed to repair %q in %q: %s", short, recorder, err)
+			continue
+		}
+		repairs.WithLabelValues("read").Inc()
+	}
+}
+
+func isMiss(err error) bool {
+	switch errors.Cause(err) {
+	case storage.ErrShortNotSet, storage.ErrFuzzyMatchFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// editorContext attributes a copy of short to whoever last changed it in from, rather than whoever caused the copy
+func editorContext(ctx context.Context, from storage.Storage, short string) context.Context {
+	var editor string
+	if ms, ok := from.(storage.MetadataStorage); ok {
+		if md, err := ms.Metadata(ctx, short); err == nil {
+			editor = md.LastEditor
+		}
+	}
+
+	return storage.WithUser(ctx, editor)
+}
@@ -0,0 +1,197 @@
+package multistorage
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thomasdesr/go-shorten/storage"
+)
+
+var syncConflicts = prometheus.NewGauge(prometheus.GaugeOpts{
+	Subsystem: "multistorage",
+	Name:      "sync_conflicts",
+	Help:      "How many shorts the last Sync found pointing at different URLs in different stores",
+})
+
+// SyncReport is what Sync changed and what it couldn't
+type SyncReport struct {
+	// Copied is how many links were saved into stores that didn't have them
+	Copied int
+	// Conflicts are the shorts that stores disagree on, which Sync leaves alone
+	Conflicts []Conflict
+}
+
+type Conflict struct {
+	Short string
+	// URLs has the URL each store has for Short in store order, "" where the store doesn't have it or doesn't take part
+	URLs []string
+}
+
+// syncedLink is a link as one store listed it
+type syncedLink struct {
+	short string
+	url   string
+}
+
+// Sync copies every link into each of the stores that don't have it yet. Only stores that can be listed and that save
+// links as they are (storage.ExactStorage, so not regex remaps) take part, and read-only stores are only copied from.
+// Stores are compared on each short's storage.CanonicalShort in every store taking part, so "Foo-Bar" in a store that
+// keeps shorts as given is the same link as "foobar" in one that sanitizes them. Shorts that the stores have different
+// URLs for, or that some of the stores can't save, are reported as conflicts and left for someone to sort out. Sync
+// can't tell a link that was deleted from one store apart from one that never made it there, so deleted links come
+// back unless they're deleted from every store.
+func (s *MultiStorage) Sync(ctx context.Context) (SyncReport, error) {
+	if err := s.validateStore(); err != nil {
+		return SyncReport{}, errors.Wrap(err, "failed to validate underlying store")
+	}
+
+	var (
+		listable   = make([]storage.ListableStorage, len(s.stores)) // nil for stores that don't take part
+		canonicals []storage.CanonicalStorage
+	)
+	for i, store := range s.stores {
+		ls, ok := store.(storage.ListableStorage)
+		if !ok {
+			continue
+		}
+		if _, ok := store.(storage.ExactStorage); !ok {
+			continue
+		}
+
+		listable[i] = ls
+		if cs, ok := store.(storage.CanonicalStorage); ok {
+			canonicals = append(canonicals, cs)
+		}
+	}
+	canonical := func(short string) (string, error) {
+		for _, cs := range canonicals {
+			var err error
+			if short, err = cs.CanonicalShort(short); err != nil {
+				return "", err
+			}
+		}
+		return short, nil
+	}
+
+	var report SyncReport
+	listed := make([]map[string]syncedLink, len(s.stores)) // Keyed by canonical short
+	conflicts := make(map[string]bool)
+	for i, ls := range listable {
+		if ls == nil {
+			continue
+		}
+
+		results, _, err := ls.List(ctx, "", 0)
+		if err != nil {
+			return SyncReport{}, errors.Wrapf(err, "failed to list %q", s.stores[i])
+		}
+
+		listed[i] = make(map[string]syncedLink, len(results))
+		for _, result := range results {
+			key, err := canonical(result.Link)
+			if err != nil {
+				// Some other store can't save this short, so it can't be synced
+				urls := make([]string, len(s.stores))
+				urls[i] = result.URL
+				report.Conflicts = append(report.Conflicts, Conflict{Short: result.Link, URLs: urls})
+				continue
+			}
+
+			if seen, ok := listed[i][key]; ok && seen.url != result.URL {
+				conflicts[key] = true // The store has several shorts that are the same link to the others
+			}
+			listed[i][key] = syncedLink{result.Link, result.URL}
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, links := range listed {
+		for key := range links {
+			keys[key] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	errs := new(multierror.Error)
+	for _, key := range sorted {
+		var (
+			link     syncedLink
+			source   = -1
+			conflict = conflicts[key]
+		)
+		for i, links := range listed {
+			l, ok := links[key]
+			switch {
+			case !ok:
+			case source < 0:
+				link, source = l, i
+			case l.url != link.url:
+				conflict = true
+			}
+		}
+
+		if conflict {
+			urls := make([]string, len(listed))
+			for i, links := range listed {
+				urls[i] = links[key].url
+			}
+			report.Conflicts = append(report.Conflicts, Conflict{Short: key, URLs: urls})
+			continue
+		}
+
+		saveCtx := editorContext(ctx, s.stores[source], link.short)
+		for i, links := range listed {
+			if _, ok := links[key]; links == nil || ok || s.readOnly[i] {
+				continue
+			}
+
+			if err := s.stores[i].SaveName(saveCtx, link.short, link.url); err != nil {
+				multierror.Append(
+					errs,
+					errors.Wrapf(err, "failed to copy %q into %q", link.short, s.stores[i]),
+				)
+				continue
+			}
+			report.Copied++
+			repairs.WithLabelValues("sync").Inc()
+		}
+	}
+
+	return report, errs.ErrorOrNil()
+}
+
+// SyncEvery runs Sync every interval until ctx is done, logging what it did and any conflicts it found
+func (s *MultiStorage) SyncEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := s.Sync(ctx)
+		if err != nil {
+			log.Printf("Error syncing stores: %s", err)
+		} else {
+			syncConflicts.Set(float64(len(report.Conflicts)))
+		}
+		if report.Copied > 0 {
+			log.Printf("Sync copied %d links into stores that were missing them", report.Copied)
+		}
+		for _, conflict := range report.Conflicts {
+			log.Printf("Sync conflict: stores disagree on %q: %q", conflict.Short, conflict.URLs)
+		}
+	}
+}
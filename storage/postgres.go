@@ -3,17 +3,26 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"log"
 	"regexp"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
 type Postgres struct {
-	dbx *sqlx.DB
+	RandLength int
+	// Fuzzy suggests a similar short when one isn't found, nil disables suggestions
+	Fuzzy *FuzzyMatcher
+	// Hits controls how visits are batched up before they're written to links_usage, changes after the first visit
+	// has been recorded are ignored
+	Hits HitRecording
+
+	dbx      *sqlx.DB
+	hits     *hitRecorder
+	hitsOnce sync.Once
 }
 
 func NewPostgres(connectURL string) (*Postgres, error) {
@@ -26,7 +35,12 @@ func NewPostgres(connectURL string) (*Postgres, error) {
 	for i := 0; i < 10; i++ {
 		err = db.Ping()
 		if err == nil {
-			return &Postgres{dbx: db}, nil
+			return &Postgres{
+				RandLength: DefaultRandLength,
+				Fuzzy:      DefaultFuzzyMatcher,
+				Hits:       DefaultHitRecording,
+				dbx:        db,
+			}, nil
 		}
 
 		time.Sleep(time.Second)
@@ -38,7 +52,7 @@ func NewPostgres(connectURL string) (*Postgres, error) {
 
 var loadQuery = `
 	SELECT
-		u.id, regexp_replace($1, l.link, u.url) AS url
+		regexp_replace($1, l.link, u.url) AS url
 	FROM
 		urls u
 	JOIN
@@ -54,77 +68,188 @@ func (p *Postgres) Load(ctx context.Context, rawShort string) (string, error) {
 		return "", err
 	}
 
-	var s struct {
-		Url string
-		ID  int
-	}
-	switch err := p.dbx.GetContext(ctx, &s, loadQuery, short); err {
+	var url string
+	switch err := p.dbx.GetContext(ctx, &url, loadQuery, short); err {
 	case nil:
 		// Short found, log access
-		if err := p.accessEvent(ctx, s.ID); err != nil {
-			log.Printf("Error logging access event: %s", err)
+		if countsVisits(ctx) {
+			p.recordVisit(short)
 		}
 	case sql.ErrNoRows:
-		fuzzyMatchedShort, err := p.loadFuzzyMatch(ctx, short)
-		if err != nil {
-			// Found something similar, pass that back
-			return fuzzyMatchedShort, err
-		}
-
-		// No fuzzy match found
-		return "", ErrShortNotSet
+		return "", p.loadFuzzyMatch(ctx, short)
 	default:
 		return "", errors.Wrap(err, "load from DB failed")
 	}
 
-	return s.Url, nil
+	return url, nil
 }
 
-func (p *Postgres) accessEvent(ctx context.Context, link_id int) error {
-	const accessEventQuery = `
-		INSERT INTO
-			links_usage(linkID)
+// RecordVisit counts a visit to short without looking it up, visits to shorts that don't match a link are dropped
+// when they're written. Like Load, it doesn't count anything if ctx is from WithoutVisit.
+func (p *Postgres) RecordVisit(ctx context.Context, rawShort string) error {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	if countsVisits(ctx) {
+		p.recordVisit(short)
+	}
+	return nil
+}
+
+// recordVisit queues a visit to short, starting the hit recorder on the first one
+func (p *Postgres) recordVisit(short string) {
+	p.hitsOnce.Do(func() {
+		p.hits = newHitRecorder(p.Hits, p.recordHits)
+	})
+	if p.hits != nil {
+		p.hits.record(short)
+	}
+}
+
+// LoadExact returns the URL of the link that is exactly short, rather than every link whose regex matches it
+func (p *Postgres) LoadExact(ctx context.Context, rawShort string) (string, error) {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return "", err
+	}
+
+	const loadExactQuery = `
 		SELECT
-			l.id
+			u.url
 		FROM
+			urls u
+		JOIN
 			links l
+				ON l.urlID = u.id
 		WHERE
-			l.id = $1
+			l.link = $1
+	`
+
+	var url string
+	switch err := p.dbx.GetContext(ctx, &url, loadExactQuery, short); err {
+	case nil:
+		return url, nil
+	case sql.ErrNoRows:
+		return "", ErrShortNotSet
+	default:
+		return "", errors.Wrap(err, "load from DB failed")
+	}
+}
+
+// recordHits adds a batch of visits to links_usage. Each visited short is counted against the link Load would've found
+// for it, preferring the link that is exactly the short, and shorts that don't match a link anymore are skipped.
+func (p *Postgres) recordHits(ctx context.Context, batch map[hitKey]hitCount) error {
+	const recordHitsQuery = `
+		INSERT INTO
+			links_usage(linkID, day, hit_count, last_accessed)
+		SELECT
+			v.linkID, v.day, sum(v.hit_count), max(v.last_accessed)
+		FROM (
+			SELECT DISTINCT ON (h.short, h.day)
+				l.id AS linkID, h.day, h.hit_count, h.last_accessed
+			FROM
+				unnest($1::text[], $2::date[], $3::integer[], $4::timestamptz[])
+					AS h(short, day, hit_count, last_accessed)
+			JOIN
+				links l
+					ON h.short ~ ('^' || l.link || '$')
+			ORDER BY
+				h.short, h.day, l.link = h.short DESC
+		) v
+		GROUP BY
+			v.linkID, v.day
 		ON CONFLICT(linkID, day)
 			DO UPDATE
-				SET hit_count = links_usage.hit_count + 1;
+				SET
+					hit_count = links_usage.hit_count + EXCLUDED.hit_count,
+					last_accessed = GREATEST(links_usage.last_accessed, EXCLUDED.last_accessed);
 	`
 
-	if _, err := p.dbx.ExecContext(ctx, accessEventQuery, link_id); err != nil {
-		return errors.Wrap(err, "load from DB failed")
+	var (
+		shorts    = make([]string, 0, len(batch))
+		days      = make([]string, 0, len(batch))
+		hitCounts = make([]int64, 0, len(batch))
+		accessed  = make([]time.Time, 0, len(batch))
+	)
+	for key, count := range batch {
+		shorts = append(shorts, key.short)
+		days = append(days, key.day.Format("2006-01-02"))
+		hitCounts = append(hitCounts, int64(count.hits))
+		accessed = append(accessed, count.last)
+	}
+
+	if _, err := p.dbx.ExecContext(
+		ctx,
+		recordHitsQuery,
+		pq.Array(shorts),
+		pq.Array(days),
+		pq.Array(hitCounts),
+		pq.Array(accessed),
+	); err != nil {
+		return errors.Wrap(err, "record hits in DB failed")
 	}
 	return nil
 }
 
-func (p *Postgres) loadFuzzyMatch(ctx context.Context, short string) (string, error) {
+// Close writes out any visits that haven't been recorded yet and closes the connection to the DB
+func (p *Postgres) Close() error {
+	p.hitsOnce.Do(func() {}) // Nothing can start recording once we're closing
+	if p.hits != nil {
+		p.hits.Close()
+	}
+
+	return errors.Wrap(p.dbx.Close(), "failed to close DB")
+}
+
+// loadFuzzyMatch returns a FuzzyMatchError with the links most similar to short, or ErrShortNotSet if there aren't
+// any close enough
+func (p *Postgres) loadFuzzyMatch(ctx context.Context, short string) error {
 	const fuzzyMatchQuery = `
 		SELECT
-			l.link
+			l.link, u.url, levenshtein(l.link, $1) AS distance, difference(l.link, $1) AS phonetic
 		FROM
 			links l
+		JOIN
+			urls u
+				ON l.urlID = u.id
 		WHERE
-				difference(l.link, $1) > 2
-			AND levenshtein(l.link, $1) < 5
-		ORDER BY levenshtein(l.link, $1)
-		LIMIT    1
+				difference(l.link, $1) >= $2
+			AND levenshtein(l.link, $1) <= $3
+		ORDER BY levenshtein(l.link, $1), l.link
+		LIMIT    $4
 	`
 
-	var fuzzyMatchedShort string
-	switch err := p.dbx.GetContext(ctx, &fuzzyMatchedShort, fuzzyMatchQuery, short); err {
-	case nil:
-		// Found a fuzzy match
-		return fuzzyMatchedShort, ErrFuzzyMatchFound
-	case sql.ErrNoRows:
+	if p.Fuzzy == nil {
+		return ErrShortNotSet
+	}
+
+	var matches []struct {
+		Link     string
+		URL      string
+		Distance int
+		Phonetic int
+	}
+	err := p.dbx.SelectContext(ctx, &matches, fuzzyMatchQuery, short, p.Fuzzy.MinPhonetic, p.Fuzzy.MaxDistance, p.Fuzzy.MaxSuggestions)
+	if err != nil {
+		return errors.Wrap(err, "load from DB for fuzzyMatch failed")
+	}
+	if len(matches) == 0 {
 		// Didn't find a good enough match, no answer
-		return "", nil
-	default:
-		return "", errors.Wrap(err, "load from DB for fuzzyMatch failed")
+		return ErrShortNotSet
+	}
+
+	suggestions := make([]Suggestion, 0, len(matches))
+	for _, match := range matches {
+		suggestions = append(suggestions, Suggestion{
+			Short: match.Link,
+			URL:   match.URL,
+			Score: fuzzyScore(short, match.Link, match.Distance, match.Phonetic),
+		})
 	}
+
+	return NewFuzzyMatchError(suggestions)
 }
 
 var saveURLQuery = `
@@ -144,21 +269,33 @@ var saveLinkQuery = `
 	)
 
 	INSERT INTO
-		links (link, urlID)
+		links (link, urlID, owner, last_editor)
 	VALUES
-		(:link, (SELECT id FROM url_id))
+		(:link, (SELECT id FROM url_id), :user, :user)
 	ON CONFLICT (link)
 		DO UPDATE
-			SET urlID = (SELECT id FROM url_id)
+			SET
+				urlID = (SELECT id FROM url_id),
+				owner = COALESCE(NULLIF(links.owner, ''), :user),
+				last_editor = :user
 			WHERE links.link = :link
 	;
 `
 
+var saveHistoryQuery = `
+	INSERT INTO
+		links_history (link, url, username)
+	VALUES
+		(:link, :url, :user)
+	;
+`
+
 func saveLink(ctx context.Context, dbx *sqlx.DB, short string, url string) error {
 	tx, err := dbx.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to start transaction")
 	}
+	defer tx.Rollback()
 
 	if _, err := tx.NamedExecContext(
 		ctx,
@@ -168,16 +305,20 @@ func saveLink(ctx context.Context, dbx *sqlx.DB, short string, url string) error
 		return errors.Wrap(err, "failed to insert url")
 	}
 
-	if _, err := tx.NamedExecContext(ctx,
-		saveLinkQuery,
-		&struct {
-			Link string
-			URL  string
-		}{short, url},
-	); err != nil {
+	change := &struct {
+		Link string
+		URL  string
+		User string
+	}{short, url, UserFromContext(ctx)}
+
+	if _, err := tx.NamedExecContext(ctx, saveLinkQuery, change); err != nil {
 		return errors.Wrap(err, "failed to insert short")
 	}
 
+	if _, err := tx.NamedExecContext(ctx, saveHistoryQuery, change); err != nil {
+		return errors.Wrap(err, "failed to insert change history")
+	}
+
 	if err := tx.Commit(); err != nil {
 		return errors.Wrap(err, "SaveName transaction failed")
 	}
@@ -197,6 +338,492 @@ func (p *Postgres) SaveName(ctx context.Context, rawShort string, url string) er
 	return saveLink(ctx, p.dbx, short, url)
 }
 
+// ValidateLink checks that short is a valid regex and url an absolute URL, which is all SaveName needs
+func (p *Postgres) ValidateLink(short string, url string) error {
+	if _, err := postgresSanitizeShort(short); err != nil {
+		return err
+	}
+
+	_, err := validateURL(url)
+	return err
+}
+
+var linkMatchesQuery = `
+	SELECT EXISTS (
+		SELECT
+			1
+		FROM
+			links l
+		WHERE
+			$1 ~ ('^' || l.link || '$')
+	)
+`
+
+var claimLinkQuery = `
+	INSERT INTO
+		links (link, urlID, owner, last_editor)
+	VALUES
+		(:link, (SELECT id FROM urls WHERE url = :url), :user, :user)
+	ON CONFLICT (link)
+		DO NOTHING
+	;
+`
+
+// claimLink saves short -> url only if no existing link (including regex links) would already match short
+func claimLink(ctx context.Context, dbx *sqlx.DB, short string, url string) (bool, error) {
+	tx, err := dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, linkMatchesQuery, short); err != nil {
+		return false, errors.Wrap(err, "failed to check for existing link")
+	}
+	if exists {
+		return false, nil
+	}
+
+	if _, err := tx.NamedExecContext(
+		ctx,
+		saveURLQuery,
+		&struct{ URL string }{url},
+	); err != nil {
+		return false, errors.Wrap(err, "failed to insert url")
+	}
+
+	change := &struct {
+		Link string
+		URL  string
+		User string
+	}{short, url, UserFromContext(ctx)}
+
+	res, err := tx.NamedExecContext(ctx, claimLinkQuery, change)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to insert short")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.NamedExecContext(ctx, saveHistoryQuery, change); err != nil {
+		return false, errors.Wrap(err, "failed to insert change history")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "Save transaction failed")
+	}
+
+	return true, nil
+}
+
+func (p *Postgres) Save(ctx context.Context, url string) (string, error) {
+	if _, err := validateURL(url); err != nil {
+		return "", err
+	}
+
+	return GenerateShort(p.RandLength, func(short string) (bool, error) {
+		return claimLink(ctx, p.dbx, short, url)
+	})
+}
+
+// GeneratedLength returns how many characters the shorts Save generates have
+func (p *Postgres) GeneratedLength() int {
+	return RandLength(p.RandLength)
+}
+
+func (p *Postgres) Metadata(ctx context.Context, rawShort string) (LinkMetadata, error) {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	const metadataQuery = `
+		SELECT
+			l.owner, l.last_editor AS lasteditor
+		FROM
+			links l
+		WHERE
+			l.link = $1
+	`
+
+	var md LinkMetadata
+	switch err := p.dbx.GetContext(ctx, &md, metadataQuery, short); err {
+	case nil:
+		return md, nil
+	case sql.ErrNoRows:
+		return md, ErrShortNotSet
+	default:
+		return md, errors.Wrap(err, "load metadata from DB failed")
+	}
+}
+
+func (p *Postgres) History(ctx context.Context, rawShort string) ([]HistoryEntry, error) {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return nil, err
+	}
+
+	const historyQuery = `
+		SELECT
+			h.id::text AS revision, h.url, h.username AS user, h.changed_at AS time
+		FROM
+			links_history h
+		WHERE
+			h.link = $1
+		ORDER BY
+			h.changed_at, h.id
+	`
+
+	var history []HistoryEntry
+	if err := p.dbx.SelectContext(ctx, &history, historyQuery, short); err != nil {
+		return nil, errors.Wrap(err, "load history from DB failed")
+	}
+	if len(history) == 0 {
+		return nil, ErrShortNotSet
+	}
+
+	return history, nil
+}
+
+func (p *Postgres) Revert(ctx context.Context, short string, revision string) error {
+	url, err := revisionURL(ctx, p, short, revision)
+	if err != nil {
+		return err
+	}
+
+	return p.SaveName(ctx, short, url)
+}
+
+func (p *Postgres) ImportHistory(ctx context.Context, rawShort string, url string, history []HistoryEntry) error {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+	if _, err := validateURL(url); err != nil {
+		return err
+	}
+
+	const importLinkQuery = `
+		INSERT INTO
+			links (link, urlID, owner, last_editor)
+		VALUES
+			(:link, (SELECT id FROM urls WHERE url = :url), :owner, :lasteditor)
+		ON CONFLICT (link)
+			DO UPDATE
+				SET
+					urlID = (SELECT id FROM urls WHERE url = :url),
+					owner = :owner,
+					last_editor = :lasteditor
+		;
+	`
+
+	const clearHistoryQuery = `
+		DELETE FROM
+			links_history
+		WHERE
+			link = $1
+	`
+
+	const importHistoryQuery = `
+		INSERT INTO
+			links_history (link, url, username, changed_at)
+		VALUES
+			(:link, :url, :user, :time)
+		;
+	`
+
+	tx, err := p.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExecContext(ctx, saveURLQuery, &struct{ URL string }{url}); err != nil {
+		return errors.Wrap(err, "failed to insert url")
+	}
+
+	md := historyMetadata(history)
+	if _, err := tx.NamedExecContext(ctx, importLinkQuery, &struct {
+		Link string
+		URL  string
+		LinkMetadata
+	}{short, url, md}); err != nil {
+		return errors.Wrap(err, "failed to insert short")
+	}
+
+	if _, err := tx.ExecContext(ctx, clearHistoryQuery, short); err != nil {
+		return errors.Wrap(err, "failed to clear existing change history")
+	}
+
+	for _, entry := range history {
+		if _, err := tx.NamedExecContext(ctx, importHistoryQuery, &struct {
+			Link string
+			HistoryEntry
+		}{short, entry}); err != nil {
+			return errors.Wrap(err, "failed to insert change history")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "ImportHistory transaction failed")
+	}
+
+	return nil
+}
+
+// linkID looks up the id of an exact link, returning ErrShortNotSet if it doesn't exist
+func linkID(ctx context.Context, q sqlx.QueryerContext, short string) (int, error) {
+	var id int
+	switch err := sqlx.GetContext(ctx, q, &id, `SELECT id FROM links WHERE link = $1`, short); err {
+	case nil:
+		return id, nil
+	case sql.ErrNoRows:
+		return 0, ErrShortNotSet
+	default:
+		return 0, errors.Wrap(err, "load link from DB failed")
+	}
+}
+
+func (p *Postgres) Usage(ctx context.Context, rawShort string) ([]UsageEntry, error) {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := linkID(ctx, p.dbx, short)
+	if err != nil {
+		return nil, err
+	}
+
+	const usageQuery = `
+		SELECT
+			lu.day, lu.hit_count AS hitcount
+		FROM
+			links_usage lu
+		WHERE
+			lu.linkID = $1
+		ORDER BY
+			lu.day
+	`
+
+	var usage []UsageEntry
+	if err := p.dbx.SelectContext(ctx, &usage, usageQuery, id); err != nil {
+		return nil, errors.Wrap(err, "load usage from DB failed")
+	}
+
+	return usage, nil
+}
+
+func (p *Postgres) ImportUsage(ctx context.Context, rawShort string, usage []UsageEntry) error {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	const clearUsageQuery = `
+		DELETE FROM
+			links_usage
+		WHERE
+			linkID = $1
+	`
+
+	const importUsageQuery = `
+		INSERT INTO
+			links_usage (linkID, day, hit_count, last_accessed)
+		VALUES
+			($1, $2, $3, NULL)
+	`
+
+	tx, err := p.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	id, err := linkID(ctx, tx, short)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, clearUsageQuery, id); err != nil {
+		return errors.Wrap(err, "failed to clear existing usage")
+	}
+
+	for _, entry := range usage {
+		if _, err := tx.ExecContext(ctx, importUsageQuery, id, entry.Day, entry.HitCount); err != nil {
+			return errors.Wrap(err, "failed to insert usage")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "ImportUsage transaction failed")
+	}
+
+	return nil
+}
+
+func (p *Postgres) Stats(ctx context.Context, rawShort string, days int) (LinkStats, error) {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return LinkStats{}, err
+	}
+
+	id, err := linkID(ctx, p.dbx, short)
+	if err != nil {
+		return LinkStats{}, err
+	}
+
+	const statsUsageQuery = `
+		SELECT
+			lu.day, lu.hit_count AS hitcount
+		FROM
+			links_usage lu
+		WHERE
+			lu.linkID = $1 AND lu.day >= CURRENT_DATE - $2::integer
+		ORDER BY
+			lu.day
+	`
+
+	// Usage imported or recorded before last_accessed existed only knows which day it was
+	const lastAccessedQuery = `
+		SELECT
+			COALESCE(max(lu.last_accessed), max(lu.day)::timestamptz)
+		FROM
+			links_usage lu
+		WHERE
+			lu.linkID = $1
+	`
+
+	stats := LinkStats{Usage: []UsageEntry{}}
+	if err := p.dbx.SelectContext(ctx, &stats.Usage, statsUsageQuery, id, days); err != nil {
+		return LinkStats{}, errors.Wrap(err, "load usage from DB failed")
+	}
+	for _, entry := range stats.Usage {
+		stats.TotalHits += entry.HitCount
+	}
+
+	if err := p.dbx.GetContext(ctx, &stats.LastAccessed, lastAccessedQuery, id); err != nil {
+		return LinkStats{}, errors.Wrap(err, "load last access from DB failed")
+	}
+
+	return stats, nil
+}
+
+// CanonicalShort checks that short is a valid regex and returns it as is, since Postgres saves shorts as the regex
+// they were given
+func (p *Postgres) CanonicalShort(rawShort string) (string, error) {
+	return postgresSanitizeShort(rawShort)
+}
+
+func (p *Postgres) Delete(ctx context.Context, rawShort string) error {
+	short, err := postgresSanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	const deleteUsageQuery = `
+		DELETE FROM
+			links_usage lu
+		USING
+			links l
+		WHERE
+				lu.linkID = l.id
+			AND l.link = $1
+	`
+
+	const deleteLinkQuery = `
+		DELETE FROM
+			links
+		WHERE
+			link = $1
+		RETURNING
+			urlID
+	`
+
+	const deleteHistoryQuery = `
+		DELETE FROM
+			links_history
+		WHERE
+			link = $1
+	`
+
+	const deleteOrphanedURLQuery = `
+		DELETE FROM
+			urls u
+		WHERE
+				u.id = $1
+			AND NOT EXISTS (SELECT 1 FROM links l WHERE l.urlID = u.id)
+	`
+
+	tx, err := p.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteUsageQuery, short); err != nil {
+		return errors.Wrap(err, "failed to delete link usage")
+	}
+
+	var urlID int
+	switch err := tx.GetContext(ctx, &urlID, deleteLinkQuery, short); err {
+	case nil:
+	case sql.ErrNoRows:
+		return ErrShortNotSet
+	default:
+		return errors.Wrap(err, "failed to delete link")
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteOrphanedURLQuery, urlID); err != nil {
+		return errors.Wrap(err, "failed to delete orphaned url")
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteHistoryQuery, short); err != nil {
+		return errors.Wrap(err, "failed to delete change history")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "Delete transaction failed")
+	}
+
+	return nil
+}
+
+func (p *Postgres) List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+	const listQuery = `
+		SELECT
+			l.link, u.url
+		FROM
+			links l
+		JOIN
+			urls u
+				ON l.urlID = u.id
+		WHERE
+			l.link COLLATE "C" > $1
+		ORDER BY
+			l.link COLLATE "C"
+		LIMIT
+			$2
+	`
+
+	// Links are compared bytewise (COLLATE "C") so pages line up with every other storage's ordering.
+	// Grab one more than asked for so we know if there is another page
+	var queryLimit interface{}
+	if limit > 0 {
+		queryLimit = limit + 1
+	}
+
+	var results []ListResult
+	if err := p.dbx.SelectContext(ctx, &results, listQuery, cursor, queryLimit); err != nil {
+		return nil, "", errors.Wrap(err, "list from DB failed")
+	}
+
+	results, next := pageListResults(results, limit)
+	return results, next, nil
+}
+
 func (p *Postgres) Search(ctx context.Context, searchTerm string) ([]SearchResult, error) {
 	const setLimitQuery = `
 		SELECT set_limit(0.2)
@@ -27,6 +27,6 @@ func cleanupPostgresStorage() error {
 		return errors.Wrap(err, "failed to connect to Postgres")
 	}
 
-	_, err = dbx.Exec("DELETE FROM LINKS; DELETE FROM URLS;")
+	_, err = dbx.Exec("DELETE FROM LINKS_HISTORY; DELETE FROM LINKS; DELETE FROM URLS;")
 	return err
 }
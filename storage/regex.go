@@ -1,53 +1,464 @@
 package storage
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
+// Remap sends shorts matching Pattern to Replacement. Remaps with a higher Priority are tried first, remaps with the
+// same Priority are tried in the order they were given.
+type Remap struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+	Priority    int    `yaml:"priority,omitempty"`
+	// Anchored remaps only match when Pattern matches the whole short
+	Anchored bool `yaml:"anchored,omitempty"`
+
+	// Comment is the comment above the remap in a remap file, it is kept when the file is rewritten
+	Comment string `yaml:"-"`
+}
+
 type remap struct {
-	Regex       *regexp.Regexp
-	Replacement string
+	Regex *regexp.Regexp
+	// anchored is Regex wrapped so that it has to match the whole short
+	anchored *regexp.Regexp
+	Remap
 }
 
 type Regex struct {
+	// Anchored makes every remap match the whole short, rather than just part of it. It should be set before the
+	// storage is used.
+	Anchored bool
+
+	// path is where remaps are persisted, remaps can only be changed at runtime when it is set
+	path string
+	// header is the comment at the top of the remap file
+	header string
+
 	remaps []remap
+	mu     sync.RWMutex
 }
 
+// NewRegex creates a Regex storage that tries remaps in priority order
+func NewRegex(remaps []Remap) (*Regex, error) {
+	compiled, err := compileRemaps(remaps)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Regex{
+		remaps: compiled,
+	}, nil
+}
+
+// NewRegexFromList creates a Regex storage from a map of regexes to replacements. Maps have no order, so the remaps are
+// tried in order of their regexes; use a remap file to control which remap wins when several match.
 func NewRegexFromList(redirects map[string]string) (*Regex, error) {
-	remaps := make([]remap, 0, len(redirects))
+	return NewRegex(remapsFromList(redirects))
+}
 
+// remapsFromList turns a map of regexes to replacements into remaps, in order of their regexes
+func remapsFromList(redirects map[string]string) []Remap {
+	remaps := make([]Remap, 0, len(redirects))
 	for regexString, redirect := range redirects {
-		r, err := regexp.Compile(regexString)
+		remaps = append(remaps, Remap{
+			Pattern:     regexString,
+			Replacement: redirect,
+		})
+	}
+
+	sort.Slice(remaps, func(i, j int) bool {
+		return remaps[i].Pattern < remaps[j].Pattern
+	})
+
+	return remaps
+}
+
+// ReplaceRemaps swaps the remaps of a Regex that isn't backed by a file for a map of regexes to replacements, the same
+// way NewRegexFromList would've set them up. The current remaps are kept if any of the new ones are invalid. Remaps
+// backed by a file are changed through the file (see Reload), so it returns ErrRegexReadOnly for those.
+func (r *Regex) ReplaceRemaps(redirects map[string]string) error {
+	if r.path != "" {
+		return ErrRegexReadOnly
+	}
+
+	compiled, err := compileRemaps(remapsFromList(redirects))
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.remaps = compiled
+	r.mu.Unlock()
+
+	return nil
+}
+
+// NewRegexFromFile loads remaps from a YAML (or JSON) file, starting empty if it doesn't exist yet. The file is a list
+// of remaps, e.g.:
+//
+//	# Tickets
+//	- pattern: jira/(?P<ticket>[A-Z]+-\d+)
+//	  replacement: https://atlassian.net/browse/${ticket}
+//	  priority: 10
+//	  anchored: true
+//
+// Changes made with SaveName and Delete are written back to the file so they survive restarts.
+func NewRegexFromFile(path string) (*Regex, error) {
+	header, remaps, err := readRemapFile(path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return &Regex{path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewRegex(remaps)
+	if err != nil {
+		return nil, err
+	}
+	r.path = path
+	r.header = header
+
+	return r, nil
+}
+
+// Reload re-reads the remaps from r's file, keeping the current remaps if the file can't be loaded. It does nothing when
+// r isn't backed by a file.
+func (r *Regex) Reload(ctx context.Context) error {
+	if r.path == "" {
+		return nil
+	}
+
+	header, remaps, err := readRemapFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	compiled, err := compileRemaps(remaps)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.header, r.remaps = header, compiled
+	r.mu.Unlock()
+
+	return nil
+}
+
+// LoadRemapFile reads the remaps from a file in the format NewRegexFromFile uses, without validating them
+func LoadRemapFile(path string) ([]Remap, error) {
+	_, remaps, err := readRemapFile(path)
+	return remaps, err
+}
+
+func readRemapFile(path string) (string, []Remap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read regex file")
+	}
+
+	header, remaps, err := parseRemapFile(b)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to parse regex file %s", path)
+	}
+
+	return header, remaps, nil
+}
+
+// parseRemapFile parses the contents of a remap file, keeping the comments so the file can be written back out
+func parseRemapFile(b []byte) (string, []Remap, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return "", nil, err
+	}
+	if len(doc.Content) == 0 {
+		// Nothing but comments
+		return doc.HeadComment, nil, nil
+	}
+
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return "", nil, errors.Errorf("line %d: expected a list of remaps", list.Line)
+	}
+
+	remaps := make([]Remap, 0, len(list.Content))
+	for _, item := range list.Content {
+		var remap Remap
+		if err := item.Decode(&remap); err != nil {
+			return "", nil, errors.Wrapf(err, "line %d", item.Line)
+		}
+		remap.Comment = item.HeadComment
+
+		remaps = append(remaps, remap)
+	}
+
+	return doc.HeadComment, remaps, nil
+}
+
+// formatRemapFile is the inverse of parseRemapFile
+func formatRemapFile(header string, remaps []remap) ([]byte, error) {
+	list := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, remap := range remaps {
+		var item yaml.Node
+		if err := item.Encode(remap.Remap); err != nil {
+			return nil, err
+		}
+		item.HeadComment = remap.Comment
+
+		list.Content = append(list.Content, &item)
+	}
+
+	doc := &yaml.Node{
+		Kind:        yaml.DocumentNode,
+		HeadComment: header,
+		Content:     []*yaml.Node{list},
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compileRemaps compiles each of the remaps and sorts them into the order they should be tried in
+func compileRemaps(remaps []Remap) ([]remap, error) {
+	compiled := make([]remap, 0, len(remaps))
+	for _, r := range remaps {
+		rm, err := compileRemap(r)
 		if err != nil {
 			return nil, err
 		}
-		remaps = append(remaps, remap{
-			Regex:       r,
-			Replacement: redirect,
-		})
+		compiled = append(compiled, rm)
 	}
 
-	return &Regex{
-		remaps: remaps,
+	sortRemaps(compiled)
+	return compiled, nil
+}
+
+func sortRemaps(remaps []remap) {
+	sort.SliceStable(remaps, func(i, j int) bool {
+		return remaps[i].Priority > remaps[j].Priority
+	})
+}
+
+var replacementRefRegex = regexp.MustCompile(`\$(\$|\{([A-Za-z0-9_]*)\}|([A-Za-z0-9_]+))`)
+
+// compileRemap checks that the pattern compiles and that the replacement only uses groups that exist in it
+func compileRemap(r Remap) (remap, error) {
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return remap{}, errors.Wrapf(ErrInvalidRemap, "%q doesn't compile: %s", r.Pattern, err)
+	}
+	anchored, err := regexp.Compile(`^(?:` + r.Pattern + `)$`)
+	if err != nil {
+		return remap{}, errors.Wrapf(ErrInvalidRemap, "%q doesn't compile: %s", r.Pattern, err)
+	}
+
+	for _, match := range replacementRefRegex.FindAllStringSubmatch(r.Replacement, -1) {
+		if match[1] == "$" {
+			// Escaped dollar sign
+			continue
+		}
+
+		group := match[2] + match[3]
+		if n, err := strconv.Atoi(group); err == nil && n <= re.NumSubexp() {
+			continue
+		}
+		if re.SubexpIndex(group) >= 0 {
+			continue
+		}
+
+		return remap{}, errors.Wrapf(ErrInvalidRemap, "replacement uses %s which isn't a group in %q", match[0], r.Pattern)
+	}
+
+	return remap{
+		Regex:    re,
+		anchored: anchored,
+		Remap:    r,
 	}, nil
 }
 
-func (r Regex) Load(ctx context.Context, short string) (string, error) {
+// regexFor returns the regex remap should be matched with
+func (r *Regex) regexFor(remap remap) *regexp.Regexp {
+	if r.Anchored || remap.Anchored {
+		return remap.anchored
+	}
+	return remap.Regex
+}
+
+func (r *Regex) Load(ctx context.Context, short string) (string, error) {
+	_, long, ok := r.Match(short)
+	if !ok {
+		return "", ErrShortNotSet
+	}
+
+	return long, nil
+}
+
+// Match returns the remap that short is sent to by, along with the URL it's sent to
+func (r *Regex) Match(short string) (Remap, string, bool) {
 	// Regex intentionally doesn't do sanitization, each regex can have whatever flexability it wants
 
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	for _, remap := range r.remaps {
-		if remap.Regex.MatchString(short) {
-			return remap.Regex.ReplaceAllString(short, remap.Replacement), nil
+		if re := r.regexFor(remap); re.MatchString(short) {
+			return remap.Remap, re.ReplaceAllString(short, remap.Replacement), true
 		}
 	}
 
-	return "", ErrShortNotSet
+	return Remap{}, "", false
+}
+
+// Remaps returns the remaps in the order they are tried
+func (r *Regex) Remaps() []Remap {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	remaps := make([]Remap, 0, len(r.remaps))
+	for _, remap := range r.remaps {
+		remaps = append(remaps, remap.Remap)
+	}
+
+	return remaps
 }
 
-func (r Regex) SaveName(ctx context.Context, short string, long string) error {
+// SaveName adds a remap from the regex short to the replacement long, or changes the replacement if the regex already
+// exists. New remaps are tried after all of the existing ones with the default priority.
+func (r *Regex) SaveName(ctx context.Context, short string, long string) error {
 	// Regex intentionally doesn't do sanitization, each regex can have whatever flexability it wants
 
-	return fmt.Errorf("regex doesn't yet support saving after creation")
+	if err := r.ValidateLink(short, long); err != nil {
+		return err
+	}
+	rm, err := compileRemap(Remap{Pattern: short, Replacement: long})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaps := make([]remap, 0, len(r.remaps)+1)
+	replaced := false
+	for _, existing := range r.remaps {
+		if existing.Pattern == short {
+			// Keep the existing priority and comment
+			existing.Replacement, replaced = long, true
+		}
+		remaps = append(remaps, existing)
+	}
+	if !replaced {
+		remaps = append(remaps, rm)
+		sortRemaps(remaps)
+	}
+
+	return r.setRemaps(remaps)
+}
+
+// ValidateLink checks that short compiles as a remap with long as its replacement, and that r can save remaps at all
+func (r *Regex) ValidateLink(short string, long string) error {
+	if r.path == "" {
+		return ErrRegexReadOnly
+	}
+
+	if _, err := compileRemap(Remap{Pattern: short, Replacement: long}); err != nil {
+		return err
+	}
+
+	_, err := validateURL(long)
+	return err
+}
+
+func (r *Regex) Delete(ctx context.Context, short string) error {
+	if r.path == "" {
+		return ErrRegexReadOnly
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaps := make([]remap, 0, len(r.remaps))
+	for _, existing := range r.remaps {
+		if existing.Pattern != short {
+			remaps = append(remaps, existing)
+		}
+	}
+	if len(remaps) == len(r.remaps) {
+		return ErrShortNotSet
+	}
+
+	return r.setRemaps(remaps)
+}
+
+// List returns the remaps sorted by their regex, with the regex as the Link and the replacement as the URL
+func (r *Regex) List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var results []ListResult
+	for _, remap := range r.remaps {
+		if remap.Pattern > cursor {
+			results = append(results, ListResult{
+				Link: remap.Pattern,
+				URL:  remap.Replacement,
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Link < results[j].Link
+	})
+
+	results, next := pageListResults(results, limit)
+	return results, next, nil
+}
+
+// setRemaps persists remaps to r's file before making them live, callers must hold r.mu
+func (r *Regex) setRemaps(remaps []remap) error {
+	b, err := formatRemapFile(r.header, remaps)
+	if err != nil {
+		return errors.Wrap(err, "failed to format regex file")
+	}
+
+	// Write to a temporary file and rename it over the old one so we never leave a half written file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary regex file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write regex file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write regex file")
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return errors.Wrap(err, "failed to replace regex file")
+	}
+
+	r.remaps = remaps
+	return nil
 }
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ShadowedRemap is a remap that an earlier remap matches everything for, so it will rarely if ever be used
+type ShadowedRemap struct {
+	Remap Remap
+	By    Remap
+}
+
+// exampleRunes are used for . when building example shorts, one per example
+var exampleRunes = [...]rune{'a', 'Z', '7'}
+
+// Shadowed reports remaps that are shadowed by an earlier, broader remap. It's a heuristic: a remap counts as shadowed
+// when an earlier remap matches each of a handful of example shorts built from its regex.
+func (r *Regex) Shadowed() []ShadowedRemap {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var shadowed []ShadowedRemap
+	for i, later := range r.remaps {
+		examples := regexExamples(r.regexFor(later))
+		if len(examples) == 0 {
+			continue
+		}
+
+		for _, earlier := range r.remaps[:i] {
+			if matchesAll(r.regexFor(earlier), examples) {
+				shadowed = append(shadowed, ShadowedRemap{
+					Remap: later.Remap,
+					By:    earlier.Remap,
+				})
+				break
+			}
+		}
+	}
+
+	return shadowed
+}
+
+func matchesAll(re *regexp.Regexp, examples []string) bool {
+	for _, example := range examples {
+		if !re.MatchString(example) {
+			return false
+		}
+	}
+	return true
+}
+
+// regexExamples builds a few different shorts that re matches
+func regexExamples(re *regexp.Regexp) []string {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	parsed = parsed.Simplify()
+
+	var examples []string
+	for variant := range exampleRunes {
+		var b strings.Builder
+		writeExample(&b, parsed, variant)
+
+		// Assertions like \b are ignored while building examples, so make sure re actually matches what we built
+		if example := b.String(); re.MatchString(example) {
+			examples = append(examples, example)
+		}
+	}
+
+	return examples
+}
+
+func writeExample(b *strings.Builder, re *syntax.Regexp, variant int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		b.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return
+		}
+		// Rune is a list of lo, hi pairs; alternate between the ends of the ranges
+		pair := variant % (len(re.Rune) / 2)
+		b.WriteRune(re.Rune[2*pair+variant%2])
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		b.WriteRune(exampleRunes[variant])
+	case syntax.OpCapture:
+		writeExample(b, re.Sub[0], variant)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			writeExample(b, sub, variant)
+		}
+	case syntax.OpAlternate:
+		writeExample(b, re.Sub[variant%len(re.Sub)], variant)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		for i := 0; i < exampleRepeats(re, variant); i++ {
+			writeExample(b, re.Sub[0], variant)
+		}
+	}
+	// Everything else (^, $, \b, ...) doesn't consume any input
+}
+
+func exampleRepeats(re *syntax.Regexp, variant int) int {
+	switch re.Op {
+	case syntax.OpStar:
+		return variant
+	case syntax.OpPlus:
+		return 1 + variant
+	case syntax.OpQuest:
+		return variant % 2
+	default:
+		if re.Max >= 0 && re.Min+variant > re.Max {
+			return re.Max
+		}
+		return re.Min + variant
+	}
+}
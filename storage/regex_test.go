@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -40,3 +42,297 @@ func TestRegexLoad(t *testing.T) {
 		}
 	}
 }
+
+func TestRegexSaveNameValidation(t *testing.T) {
+	r, err := NewRegexFromFile(filepath.Join(t.TempDir(), "remaps.json"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	testTable := []struct {
+		regex       string
+		replacement string
+		err         error
+	}{
+		{regex: `jira/(.+)`, replacement: "https://atlassian.net/browse/$1"},
+		{regex: `jira/(?P<ticket>.+)`, replacement: "https://atlassian.net/browse/${ticket}"},
+		{regex: `cost/(.+)`, replacement: "https://example.com/$$/${1}"},
+		{regex: `jira/(.+`, replacement: "https://atlassian.net/browse/$1", err: ErrInvalidRemap},
+		{regex: `jira/(.+)`, replacement: "https://atlassian.net/browse/$2", err: ErrInvalidRemap},
+		{regex: `jira/(.+)`, replacement: "https://atlassian.net/browse/$1x", err: ErrInvalidRemap},
+		{regex: `jira/(?P<ticket>.+)`, replacement: "https://atlassian.net/browse/${issue}", err: ErrInvalidRemap},
+		{regex: `jira/(.+)`, replacement: "atlassian.net/browse/$1", err: ErrURLNotAbsolute},
+	}
+
+	for _, tt := range testTable {
+		err := r.SaveName(context.Background(), tt.regex, tt.replacement)
+		if errors.Cause(err) != tt.err {
+			t.Errorf("SaveName(%q, %q): actual err (%v) != expected err (%v)", tt.regex, tt.replacement, err, tt.err)
+		}
+	}
+}
+
+func TestRegexReadOnlyWithoutFile(t *testing.T) {
+	r, err := NewRegexFromList(map[string]string{`jira/(.+)`: "https://atlassian.net/browse/$1"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	if err := r.SaveName(context.Background(), `pr/(\d+)`, "https://example.com/$1"); err != ErrRegexReadOnly {
+		t.Errorf("SaveName: actual err (%v) != expected err (%v)", err, ErrRegexReadOnly)
+	}
+	if err := r.Delete(context.Background(), `jira/(.+)`); err != ErrRegexReadOnly {
+		t.Errorf("Delete: actual err (%v) != expected err (%v)", err, ErrRegexReadOnly)
+	}
+}
+
+func TestRegexPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "remaps.json")
+
+	r, err := NewRegexFromFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	for _, remap := range [][2]string{
+		{`jira/(.+)`, "https://atlassian.net/browse/$1"},
+		{`pr/(\d+)`, "https://github.com/thomasdesr/go-shorten/pull/$1"},
+		{`docs/(.+)`, "https://docs.example.com/$1"},
+	} {
+		if err := r.SaveName(ctx, remap[0], remap[1]); err != nil {
+			t.Fatal(errors.Wrapf(err, "failed to save %q", remap[0]))
+		}
+	}
+
+	if err := r.SaveName(ctx, `jira/(.+)`, "https://jira.example.com/browse/$1"); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to change remap"))
+	}
+	if err := r.Delete(ctx, `docs/(.+)`); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to delete remap"))
+	}
+	if err := r.Delete(ctx, `docs/(.+)`); err != ErrShortNotSet {
+		t.Errorf("deleting a missing remap: actual err (%v) != expected err (%v)", err, ErrShortNotSet)
+	}
+
+	reloaded, err := NewRegexFromFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to reload storage.Regex"))
+	}
+
+	testTable := []struct {
+		in       string
+		expected string
+		err      error
+	}{
+		{in: "jira/ABC-1234", expected: "https://jira.example.com/browse/ABC-1234"},
+		{in: "pr/1234", expected: "https://github.com/thomasdesr/go-shorten/pull/1234"},
+		{in: "docs/index", err: ErrShortNotSet},
+	}
+
+	for _, tt := range testTable {
+		actual, err := reloaded.Load(ctx, tt.in)
+		if err != tt.err {
+			t.Errorf("Load(%q): actual err (%v) != expected err (%v)", tt.in, err, tt.err)
+		}
+		if actual != tt.expected {
+			t.Errorf("Load(%q): actual result (%q) != expected result (%q)", tt.in, actual, tt.expected)
+		}
+	}
+
+	list, _, err := reloaded.List(ctx, "", 10)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to list remaps"))
+	}
+	if len(list) != 2 || list[0].Link != `jira/(.+)` || list[1].Link != `pr/(\d+)` {
+		t.Errorf("unexpected remaps listed: %#v", list)
+	}
+}
+
+func TestRegexPriority(t *testing.T) {
+	r, err := NewRegex([]Remap{
+		{Pattern: `(.+)/(\d+)`, Replacement: "https://example.com/$1/$2"},
+		{Pattern: `pr/(\d+)`, Replacement: "https://github.com/thomasdesr/go-shorten/pull/$1", Priority: 10},
+		{Pattern: `issue/(\d+)`, Replacement: "https://github.com/thomasdesr/go-shorten/issues/$1"},
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	testTable := []struct {
+		in       string
+		expected string
+	}{
+		{in: "pr/1234", expected: "https://github.com/thomasdesr/go-shorten/pull/1234"},
+		{in: "issue/1234", expected: "https://example.com/issue/1234"},
+	}
+
+	for _, tt := range testTable {
+		actual, err := r.Load(context.Background(), tt.in)
+		if err != nil {
+			t.Errorf("Load(%q) failed: %v", tt.in, err)
+		}
+		if actual != tt.expected {
+			t.Errorf("Load(%q): actual result (%q) != expected result (%q)", tt.in, actual, tt.expected)
+		}
+	}
+
+	shadowed := r.Shadowed()
+	if len(shadowed) != 1 || shadowed[0].Remap.Pattern != `issue/(\d+)` || shadowed[0].By.Pattern != `(.+)/(\d+)` {
+		t.Errorf("unexpected shadowed remaps: %#v", shadowed)
+	}
+}
+
+func TestRegexShadowed(t *testing.T) {
+	testTable := []struct {
+		earlier  string
+		later    string
+		shadowed bool
+	}{
+		{earlier: `jira/(.+)`, later: `jira/(\d+)`, shadowed: true},
+		{earlier: `jira/(\d+)`, later: `jira/(.+)`, shadowed: false},
+		{earlier: `jira`, later: `jira/([A-Z]+-\d+)`, shadowed: true},
+		{earlier: `^jira$`, later: `jira/(.+)`, shadowed: false},
+		{earlier: `(pull|pr)/(\d+)`, later: `pr/(\d+)`, shadowed: true},
+		{earlier: `pr/(\d+)`, later: `(pull|pr)/(\d+)`, shadowed: false},
+	}
+
+	for _, tt := range testTable {
+		r, err := NewRegex([]Remap{
+			{Pattern: tt.earlier, Replacement: "https://example.com/"},
+			{Pattern: tt.later, Replacement: "https://example.com/"},
+		})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+		}
+
+		if shadowed := len(r.Shadowed()) > 0; shadowed != tt.shadowed {
+			t.Errorf("%q shadowed by %q: actual (%t) != expected (%t)", tt.later, tt.earlier, shadowed, tt.shadowed)
+		}
+	}
+}
+
+func TestRegexFileKeepsComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remaps.yaml")
+	contents := `# Remaps for go/
+
+# Tickets
+- pattern: jira/(.+)
+  replacement: https://atlassian.net/browse/$1
+  priority: 10
+# Pull requests
+- pattern: pr/(\d+)
+  replacement: https://github.com/thomasdesr/go-shorten/pull/$1
+`
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRegexFromFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	if err := r.SaveName(context.Background(), `docs/(.+)`, "https://docs.example.com/$1"); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to add remap"))
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := contents + `- pattern: docs/(.+)
+  replacement: https://docs.example.com/$1
+`
+	if string(b) != expected {
+		t.Errorf("rewritten file doesn't match:\n%s\nexpected:\n%s", b, expected)
+	}
+}
+
+func TestRegexAnchored(t *testing.T) {
+	r, err := NewRegex([]Remap{
+		{Pattern: `jira/(?P<ticket>[A-Z]+-\d+)`, Replacement: "https://atlassian.net/browse/${ticket}", Anchored: true},
+		{Pattern: `docs`, Replacement: "https://docs.example.com/"},
+	})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	testTable := []struct {
+		in       string
+		anchored bool
+		expected string
+		err      error
+	}{
+		{in: "jira/ABC-1234", expected: "https://atlassian.net/browse/ABC-1234"},
+		{in: "myjira/ABC-1234", err: ErrShortNotSet},
+		{in: "jira/ABC-1234/comments", err: ErrShortNotSet},
+		{in: "mydocsstuff", expected: "myhttps://docs.example.com/stuff"},
+		{in: "docs", anchored: true, expected: "https://docs.example.com/"},
+		{in: "mydocsstuff", anchored: true, err: ErrShortNotSet},
+	}
+
+	for _, tt := range testTable {
+		r.Anchored = tt.anchored
+
+		actual, err := r.Load(context.Background(), tt.in)
+		if err != tt.err {
+			t.Errorf("Load(%q): actual err (%v) != expected err (%v)", tt.in, err, tt.err)
+		}
+		if actual != tt.expected {
+			t.Errorf("Load(%q): actual result (%q) != expected result (%q)", tt.in, actual, tt.expected)
+		}
+	}
+}
+
+func TestNewRegexRejectsMissingGroups(t *testing.T) {
+	testTable := []Remap{
+		{Pattern: `jira/(.+)`, Replacement: "https://atlassian.net/browse/$2"},
+		{Pattern: `jira/(?P<ticket>.+)`, Replacement: "https://atlassian.net/browse/${issue}"},
+	}
+
+	for _, remap := range testTable {
+		if _, err := NewRegex([]Remap{remap}); errors.Cause(err) != ErrInvalidRemap {
+			t.Errorf("NewRegex(%q -> %q): actual err (%v) != expected err (%v)", remap.Pattern, remap.Replacement, err, ErrInvalidRemap)
+		}
+	}
+
+	if _, err := NewRegexFromList(map[string]string{`pr/(\d+)`: "https://example.com/$1x"}); errors.Cause(err) != ErrInvalidRemap {
+		t.Errorf("NewRegexFromList: actual err (%v) != expected err (%v)", err, ErrInvalidRemap)
+	}
+}
+
+func TestRegexReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "remaps.yaml")
+
+	write := func(contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("- pattern: jira/(.+)\n  replacement: https://atlassian.net/browse/$1\n")
+	r, err := NewRegexFromFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to create storage.Regex"))
+	}
+
+	write("- pattern: jira/(.+)\n  replacement: https://jira.example.com/browse/$1\n")
+	if err := r.Reload(ctx); err != nil {
+		t.Fatal(errors.Wrap(err, "failed to reload"))
+	}
+	if actual, _ := r.Load(ctx, "jira/ABC-1234"); actual != "https://jira.example.com/browse/ABC-1234" {
+		t.Errorf("reloaded remap wasn't used, got %q", actual)
+	}
+
+	// A broken file keeps the remaps we already have
+	write("- pattern: jira/(.+)\n  replacement: https://jira.example.com/browse/$2\n")
+	if err := r.Reload(ctx); errors.Cause(err) != ErrInvalidRemap {
+		t.Errorf("actual err (%v) != expected err (%v)", err, ErrInvalidRemap)
+	}
+	if actual, _ := r.Load(ctx, "jira/ABC-1234"); actual != "https://jira.example.com/browse/ABC-1234" {
+		t.Errorf("remaps changed after a failed reload, got %q", actual)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
+	"log"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,11 +23,20 @@ import (
 type S3 struct {
 	Client     *s3.S3
 	BucketName string
+	RandLength int
+	// Fuzzy suggests a similar short when one isn't found, nil disables suggestions
+	Fuzzy *FuzzyMatcher
 
 	storageVersion string
 	hashFunc       func(string) string
+
+	// index saves listing the whole bucket every time we need to look through all of the shorts
+	index *linkIndex
 }
 
+// s3IndexMaxAge is how long S3 trusts its index to have any links saved by other instances
+const s3IndexMaxAge = 5 * time.Minute
+
 func NewS3(awsSession *session.Session, bucketName string) (*S3, error) {
 	if awsSession == nil {
 		var err error
@@ -40,6 +52,7 @@ func NewS3(awsSession *session.Session, bucketName string) (*S3, error) {
 	s := &S3{
 		Client:     s3.New(awsSession),
 		BucketName: bucketName,
+		RandLength: DefaultRandLength,
 
 		storageVersion: "v2",
 		hashFunc: func(s string) string {
@@ -47,6 +60,7 @@ func NewS3(awsSession *session.Session, bucketName string) (*S3, error) {
 			return hex.EncodeToString(h[:])
 		},
 	}
+	s.index = newLinkIndex(s.List, s3IndexMaxAge)
 
 	_, err := s.Client.HeadBucket(&s3.HeadBucketInput{
 		Bucket: aws.String(s.BucketName),
@@ -56,8 +70,11 @@ func NewS3(awsSession *session.Session, bucketName string) (*S3, error) {
 			Bucket: aws.String(s.BucketName),
 		})
 	}
+	if err != nil {
+		return s, err
+	}
 
-	return s, err
+	return s, s.buildIndex(context.Background())
 }
 
 func (s *S3) saveKey(ctx context.Context, short, url string) (err error) {
@@ -84,13 +101,39 @@ func (s *S3) saveKey(ctx context.Context, short, url string) (err error) {
 		return errors.Wrap(err, "failed to save short url to s3")
 	}
 
+	if err := s.putObject(ctx, s.indexKey(short), strings.NewReader(url), "text/plain"); err != nil {
+		return errors.Wrap(err, "failed to add short url to the link index")
+	}
+
+	user := UserFromContext(ctx)
+
+	md, err := s.loadMetadata(ctx, s3BucketPrefix)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := json.Marshal(updateMetadata(md, user))
+	if err != nil {
+		return errors.Wrap(err, "unable to format metadata")
+	}
+
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.BucketName),
+		Key:         aws.String(path.Join(s3BucketPrefix, "metadata")),
+		Body:        bytes.NewReader(metadata),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save metadata to s3")
+	}
+
 	changeLog, err := json.Marshal(
 		struct {
 			URL  string
 			User string
 		}{
 			url,
-			"TODO",
+			user,
 		},
 	)
 	if err != nil {
@@ -112,22 +155,89 @@ func (s *S3) saveKey(ctx context.Context, short, url string) (err error) {
 		return errors.Wrap(err, "failed to save changelog to s3")
 	}
 
+	s.index.set(short, url)
 	return nil
 }
 
+// loadMetadata returns the metadata stored under prefix, or empty metadata if there isn't any
+func (s *S3) loadMetadata(ctx context.Context, s3BucketPrefix string) (LinkMetadata, error) {
+	var md LinkMetadata
+
+	resp, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(path.Join(s3BucketPrefix, "metadata")),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchKey" {
+		return md, nil
+	}
+	if err != nil {
+		return md, errors.Wrap(err, "failed to load metadata from s3")
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return md, errors.Wrap(err, "failed to parse metadata")
+	}
+
+	return md, nil
+}
+
 func (s *S3) SaveName(ctx context.Context, rawShort string, url string) error {
-	short, err := sanitizeShort(rawShort)
+	short, url, err := validateLink(rawShort, url)
 	if err != nil {
 		return err
 	}
+
+	return s.saveKey(ctx, short, url)
+}
+
+func (s *S3) Save(ctx context.Context, url string) (string, error) {
 	if _, err := validateURL(url); err != nil {
-		return err
+		return "", err
 	}
 
-	return s.saveKey(ctx, short, url)
+	return GenerateShort(s.RandLength, func(short string) (bool, error) {
+		_, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.BucketName),
+			Key:    aws.String(path.Join(s.storageVersion, s.hashFunc(short), "long")),
+		})
+		if err == nil {
+			return false, nil
+		}
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NotFound" {
+			return false, errors.Wrap(err, "failed to check if short already exists")
+		}
+
+		return true, s.saveKey(ctx, short, url)
+	})
+}
+
+// GeneratedLength returns how many characters the shorts Save generates have
+func (s *S3) GeneratedLength() int {
+	return RandLength(s.RandLength)
 }
 
 func (s *S3) Load(ctx context.Context, rawShort string) (string, error) {
+	url, err := s.loadExact(ctx, rawShort)
+	if err == ErrShortNotSet {
+		url, err = resolveTemplate(ctx, rawShort, s.loadExact)
+	}
+	if err == ErrShortNotSet {
+		return fuzzyNotFound(ctx, s.Fuzzy, rawShort, func() ([]string, error) {
+			return s.index.shorts(ctx)
+		}, s.loadExact)
+	}
+
+	return url, err
+}
+
+// LoadExact is Load without resolving parameterized links
+func (s *S3) LoadExact(ctx context.Context, rawShort string) (string, error) {
+	return s.loadExact(ctx, rawShort)
+}
+
+// loadExact looks up a single short without trying to resolve parameterized links
+func (s *S3) loadExact(ctx context.Context, rawShort string) (string, error) {
 	short, err := sanitizeShort(rawShort)
 	if err != nil {
 		return "", err
@@ -151,3 +261,424 @@ func (s *S3) Load(ctx context.Context, rawShort string) (string, error) {
 
 	return bb.String(), err
 }
+
+func (s *S3) Metadata(ctx context.Context, rawShort string) (LinkMetadata, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return LinkMetadata{}, err
+	}
+
+	s3BucketPrefix := path.Join(s.storageVersion, s.hashFunc(short))
+
+	_, err = s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(path.Join(s3BucketPrefix, "long")),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NotFound" {
+		return LinkMetadata{}, ErrShortNotSet
+	}
+	if err != nil {
+		return LinkMetadata{}, errors.Wrap(err, "failed to check if short exists")
+	}
+
+	return s.loadMetadata(ctx, s3BucketPrefix)
+}
+
+func (s *S3) History(ctx context.Context, rawShort string) ([]HistoryEntry, error) {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*string
+	err = s.Client.ListObjectsV2PagesWithContext(ctx,
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(s.BucketName),
+			Prefix: aws.String(path.Join(s.storageVersion, s.hashFunc(short), "change_history") + "/"),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				keys = append(keys, obj.Key)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list change history in s3")
+	}
+	if len(keys) == 0 {
+		return nil, ErrShortNotSet
+	}
+
+	history := make([]HistoryEntry, 0, len(keys))
+	for _, key := range keys {
+		changedAt, err := time.Parse(time.RFC3339Nano, path.Base(*key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse change history timestamp %q", *key)
+		}
+
+		resp, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.BucketName),
+			Key:    key,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load change history %q", *key)
+		}
+
+		var change struct {
+			URL  string
+			User string
+		}
+		err = json.NewDecoder(resp.Body).Decode(&change)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse change history %q", *key)
+		}
+
+		history = append(history, HistoryEntry{
+			Revision: path.Base(*key),
+
+			URL:  change.URL,
+			User: change.User,
+			Time: changedAt,
+		})
+	}
+
+	// RFC3339Nano drops trailing zeros so the keys don't sort chronologically by themselves
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+
+	return history, nil
+}
+
+func (s *S3) Revert(ctx context.Context, short string, revision string) error {
+	url, err := revisionURL(ctx, s, short, revision)
+	if err != nil {
+		return err
+	}
+
+	return s.SaveName(ctx, short, url)
+}
+
+// List pages through the bucket's index of links (see indexKey), so each page only reads the links on it
+func (s *S3) List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.BucketName),
+		Prefix: aws.String(s.indexPrefix()),
+	}
+	if cursor != "" {
+		input.StartAfter = aws.String(s.indexKey(cursor))
+	}
+	if limit > 0 {
+		input.MaxKeys = aws.Int64(int64(limit))
+	}
+
+	var (
+		keys []string
+		more bool
+	)
+	err := s.Client.ListObjectsV2PagesWithContext(ctx, input,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, obj := range page.Contents {
+				keys = append(keys, aws.StringValue(obj.Key))
+			}
+			more = aws.BoolValue(page.IsTruncated)
+			return limit <= 0 || len(keys) < limit
+		},
+	)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to list the link index in s3")
+	}
+	if limit > 0 && len(keys) > limit {
+		keys, more = keys[:limit], true
+	}
+
+	var (
+		results = make([]ListResult, 0, len(keys))
+		short   string
+	)
+	for _, key := range keys {
+		rawShort, err := hex.DecodeString(path.Base(key))
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "failed to parse link index key %q", key)
+		}
+		short = string(rawShort)
+
+		url, err := s.getString(ctx, key)
+		if errors.Cause(err) == ErrShortNotSet {
+			// Deleted since we listed it
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		results = append(results, ListResult{
+			Link: short,
+			URL:  url,
+		})
+	}
+
+	if !more {
+		return results, "", nil
+	}
+	return results, short, nil
+}
+
+func (s *S3) indexPrefix() string {
+	return s.storageVersion + "-index/"
+}
+
+// indexKey is where short's entry in the bucket's index of links is kept, holding its URL. The index is separate from
+// the links themselves, which are stored under a hash of their short, so that listing it returns links sorted by short.
+// Hex encoding the short keeps S3's key order the same as the order of the shorts.
+func (s *S3) indexKey(short string) string {
+	return s.indexPrefix() + hex.EncodeToString([]byte(short))
+}
+
+// indexBuiltKey marks a bucket as having an index entry for every link
+func (s *S3) indexBuiltKey() string {
+	return s.storageVersion + "-index.built"
+}
+
+// buildIndex adds every link saved before the bucket had an index to it. This means reading the short and URL of every
+// link in the bucket, so it's only done once per bucket.
+func (s *S3) buildIndex(ctx context.Context) error {
+	_, err := s.Client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.indexBuiltKey()),
+	})
+	if err == nil {
+		return nil
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NotFound" {
+		return errors.Wrap(err, "failed to check for the link index")
+	}
+
+	log.Printf("Building the link index for S3 bucket %s, this only happens once", s.BucketName)
+
+	var prefixes []string
+	err = s.Client.ListObjectsV2PagesWithContext(ctx,
+		&s3.ListObjectsV2Input{
+			Bucket:    aws.String(s.BucketName),
+			Prefix:    aws.String(s.storageVersion + "/"),
+			Delimiter: aws.String("/"),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, prefix := range page.CommonPrefixes {
+				prefixes = append(prefixes, aws.StringValue(prefix.Prefix))
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to list shorts in s3")
+	}
+
+	for _, prefix := range prefixes {
+		// Links saved before shorts were stored alongside them (see migrations.S3v2MigrationStore) are skipped
+		short, err := s.getString(ctx, path.Join(prefix, "short"))
+		if errors.Cause(err) == ErrShortNotSet {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		url, err := s.getString(ctx, path.Join(prefix, "long"))
+		if errors.Cause(err) == ErrShortNotSet {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.putObject(ctx, s.indexKey(short), strings.NewReader(url), "text/plain"); err != nil {
+			return errors.Wrapf(err, "failed to add %q to the link index", short)
+		}
+	}
+
+	if err := s.putObject(ctx, s.indexBuiltKey(), strings.NewReader(""), "text/plain"); err != nil {
+		return errors.Wrap(err, "failed to mark the link index as built")
+	}
+
+	log.Printf("Built the link index for S3 bucket %s with %d links", s.BucketName, len(prefixes))
+	return nil
+}
+
+// getString reads a whole object, returning ErrShortNotSet if it doesn't exist
+func (s *S3) getString(ctx context.Context, key string) (string, error) {
+	resp, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchKey" {
+		return "", ErrShortNotSet
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to load %q from s3", key)
+	}
+	defer resp.Body.Close()
+
+	var bb bytes.Buffer
+	if _, err := bb.ReadFrom(resp.Body); err != nil {
+		return "", errors.Wrapf(err, "failed to read %q", key)
+	}
+
+	return bb.String(), nil
+}
+
+func (s *S3) ImportHistory(ctx context.Context, rawShort string, url string, history []HistoryEntry) error {
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+	if _, err := validateURL(url); err != nil {
+		return err
+	}
+
+	s3BucketPrefix := path.Join(s.storageVersion, s.hashFunc(short))
+
+	if _, err := s.deletePrefix(ctx, path.Join(s3BucketPrefix, "change_history")+"/"); err != nil {
+		return errors.Wrap(err, "failed to clear existing change history")
+	}
+
+	for _, entry := range history {
+		changeLog, err := json.Marshal(
+			struct {
+				URL  string
+				User string
+			}{
+				entry.URL,
+				entry.User,
+			},
+		)
+		if err != nil {
+			return errors.Wrap(err, "unable to format change history")
+		}
+
+		if err := s.putObject(ctx,
+			path.Join(s3BucketPrefix, "change_history", entry.Time.Format(time.RFC3339Nano)),
+			bytes.NewReader(changeLog),
+			"application/json",
+		); err != nil {
+			return errors.Wrap(err, "failed to save changelog to s3")
+		}
+	}
+
+	metadata, err := json.Marshal(historyMetadata(history))
+	if err != nil {
+		return errors.Wrap(err, "unable to format metadata")
+	}
+
+	if err := s.putObject(ctx, path.Join(s3BucketPrefix, "metadata"), bytes.NewReader(metadata), "application/json"); err != nil {
+		return errors.Wrap(err, "failed to save metadata to s3")
+	}
+	if err := s.putObject(ctx, path.Join(s3BucketPrefix, "short"), strings.NewReader(short), "text/plain"); err != nil {
+		return errors.Wrap(err, "failed to save short url to s3")
+	}
+	if err := s.putObject(ctx, path.Join(s3BucketPrefix, "long"), strings.NewReader(url), "text/plain"); err != nil {
+		return errors.Wrap(err, "failed to save long url to s3")
+	}
+	if err := s.putObject(ctx, s.indexKey(short), strings.NewReader(url), "text/plain"); err != nil {
+		return errors.Wrap(err, "failed to add short url to the link index")
+	}
+
+	s.index.set(short, url)
+	return nil
+}
+
+func (s *S3) putObject(ctx context.Context, key string, body io.ReadSeeker, contentType string) error {
+	_, err := s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.BucketName),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// CanonicalShort returns the lowercased short, without the characters that are ignored in shorts
+func (s *S3) CanonicalShort(rawShort string) (string, error) {
+	return storedShort(rawShort)
+}
+
+// Delete removes every object stored for the short, including its change history
+func (s *S3) Delete(ctx context.Context, rawShort string) error {
+	rawShort, _, err := templateShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := s.deletePrefix(ctx, path.Join(s.storageVersion, s.hashFunc(short))+"/")
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrShortNotSet
+	}
+
+	if _, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.indexKey(short)),
+	}); err != nil {
+		return errors.Wrap(err, "failed to remove short url from the link index")
+	}
+
+	s.index.delete(short)
+	return nil
+}
+
+// deletePrefix removes every object under prefix, returning how many there were
+func (s *S3) deletePrefix(ctx context.Context, prefix string) (int, error) {
+	var deleted int
+	var deleteErr error
+	err := s.Client.ListObjectsV2PagesWithContext(ctx,
+		&s3.ListObjectsV2Input{
+			Bucket: aws.String(s.BucketName),
+			Prefix: aws.String(prefix),
+		},
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			if len(page.Contents) == 0 {
+				return true
+			}
+
+			objects := make([]*s3.ObjectIdentifier, 0, len(page.Contents))
+			for _, obj := range page.Contents {
+				objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+			}
+
+			resp, err := s.Client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(s.BucketName),
+				Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			})
+			if err == nil && len(resp.Errors) > 0 {
+				err = errors.Errorf("%s: %s", aws.StringValue(resp.Errors[0].Key), aws.StringValue(resp.Errors[0].Message))
+			}
+			if err != nil {
+				deleteErr = errors.Wrap(err, "failed to delete objects from s3")
+				return false
+			}
+
+			deleted += len(objects)
+			return true
+		},
+	)
+	if err != nil {
+		return deleted, errors.Wrap(err, "failed to list objects in s3")
+	}
+
+	return deleted, deleteErr
+}
+
+// Search finds links whose short or URL is similar to searchTerm, most relevant first
+func (s *S3) Search(ctx context.Context, searchTerm string) ([]SearchResult, error) {
+	return s.index.search(ctx, searchTerm)
+}
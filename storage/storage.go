@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Storage interface {
@@ -19,6 +20,160 @@ type NamedStorage interface {
 	SaveName(ctx context.Context, short string, url string) error
 }
 
+// ExactStorage is a Storage that can tell a short it has saved apart from one it only resolves, e.g. through a
+// parameterized link
+type ExactStorage interface {
+	Storage
+	// LoadExact returns the URL saved under short, without resolving parameterized links or patterns
+	LoadExact(ctx context.Context, short string) (string, error)
+}
+
+type CanonicalStorage interface {
+	Storage
+	// CanonicalShort returns the form short is saved under, e.g. lowercased or with its placeholders renamed
+	CanonicalShort(short string) (string, error)
+}
+
+type UnnamedStorage interface {
+	Storage
+	// Save takes a url, generates an unused short for it and returns the short it was saved under
+	Save(ctx context.Context, url string) (string, error)
+}
+
+// GeneratingStorage is an UnnamedStorage that says how long the shorts it generates are
+type GeneratingStorage interface {
+	UnnamedStorage
+	// GeneratedLength returns how many characters the shorts Save generates have
+	GeneratedLength() int
+}
+
+type DeletableStorage interface {
+	Storage
+	// Delete removes a short from storage, returning ErrShortNotSet if the short didn't exist
+	Delete(ctx context.Context, short string) error
+}
+
+type MetadataStorage interface {
+	Storage
+	// Metadata returns who owns and last changed a short
+	Metadata(ctx context.Context, short string) (LinkMetadata, error)
+}
+
+type LinkMetadata struct {
+	Owner      string
+	LastEditor string
+}
+
+type HistoryStorage interface {
+	Storage
+	// History returns every URL a short has pointed at, oldest first
+	History(ctx context.Context, short string) ([]HistoryEntry, error)
+}
+
+type RevertableStorage interface {
+	HistoryStorage
+	// Revert points short back at the URL it had in the given revision, recording the revert as a new change
+	Revert(ctx context.Context, short string, revision string) error
+}
+
+type HistoryImporter interface {
+	HistoryStorage
+	// ImportHistory points short at url and replaces its history with one recorded elsewhere (e.g. by another storage
+	// during a migration) instead of recording a new change. The owner and last editor are taken from the history.
+	ImportHistory(ctx context.Context, short string, url string, history []HistoryEntry) error
+}
+
+type HistoryEntry struct {
+	Revision string `json:"revision"`
+
+	URL  string    `json:"url"`
+	User string    `json:"user"`
+	Time time.Time `json:"time"`
+}
+
+type ListableStorage interface {
+	Storage
+	// List returns up to limit links sorted by short, starting after the short given as cursor. The returned cursor
+	// fetches the next page and is empty once there is nothing left. A limit <= 0 returns everything.
+	List(ctx context.Context, cursor string, limit int) ([]ListResult, string, error)
+}
+
+type ListResult struct {
+	Link string
+	URL  string
+}
+
+type UsageStorage interface {
+	Storage
+	// Usage returns how many times a short was visited on each day it was visited, oldest first
+	Usage(ctx context.Context, short string) ([]UsageEntry, error)
+}
+
+type UsageImporter interface {
+	UsageStorage
+	// ImportUsage replaces the usage of a short with usage recorded elsewhere
+	ImportUsage(ctx context.Context, short string, usage []UsageEntry) error
+}
+
+type UsageEntry struct {
+	Day      time.Time `json:"day"`
+	HitCount int       `json:"hit_count"`
+}
+
+// StatsStorage is a Storage that can report how much a link is being used
+type StatsStorage interface {
+	Storage
+	// Stats returns a short's visits from today and the previous N days, and when it was last visited at all
+	Stats(ctx context.Context, short string, days int) (LinkStats, error)
+}
+
+type LinkStats struct {
+	// Usage has an entry for each day in the period the short was visited on, oldest first
+	Usage []UsageEntry `json:"usage"`
+	// TotalHits is the sum of Usage
+	TotalHits int `json:"total_hits"`
+	// LastAccessed is nil if the short has never been visited
+	LastAccessed *time.Time `json:"last_accessed"`
+}
+
+// VisitStorage is a Storage that counts visits, so something that answered Load in its place (like a cache) can still
+// have the visit counted
+type VisitStorage interface {
+	Storage
+	// RecordVisit counts a visit to short as if Load had found it
+	RecordVisit(ctx context.Context, short string) error
+}
+
+// CompositeStorage is a Storage made out of other storages, like a multistorage. It has the methods of the optional
+// interfaces its storages might have, but can only use them if at least some of its storages can.
+type CompositeStorage interface {
+	Storage
+	// Supports returns whether an optional interface can be used, given fits to check one of its storages for it
+	Supports(fits func(Storage) bool) bool
+}
+
+// Supports returns store as T if it implements T. A CompositeStorage also needs to be able to use T, so that features
+// its storages can't back aren't offered.
+func Supports[T Storage](store Storage) (T, bool) {
+	t, ok := store.(T)
+	if !ok {
+		return t, false
+	}
+
+	if cs, ok := store.(CompositeStorage); ok {
+		fits := func(s Storage) bool {
+			_, ok := Supports[T](s)
+			return ok
+		}
+		if !cs.Supports(fits) {
+			var none T
+			return none, false
+		}
+	}
+
+	return t, true
+}
+
 type SearchableStorage interface {
 	Storage
 	// Search takes a search term and returns a number of possible shorts
@@ -30,6 +185,18 @@ type SearchResult struct {
 	URL  string
 }
 
+// ReloadableStorage is a Storage with configuration it can re-read while it's being used, e.g. on SIGHUP
+type ReloadableStorage interface {
+	Storage
+	Reload(ctx context.Context) error
+}
+
+// ClosableStorage is a Storage with work it needs to finish, like visits it hasn't written yet, before the program exits
+type ClosableStorage interface {
+	Storage
+	Close() error
+}
+
 type TopN interface {
 	Storage
 	// TopNForPeriod returns the most visited shorts in the last N days
@@ -50,8 +217,46 @@ var (
 	ErrShortNotSet = errors.New("storage layer doens't have a URL for that short code")
 
 	ErrFuzzyMatchFound = errors.New("fuzzy match found")
+
+	ErrShortGenerationFailed = errors.New("unable to generate an unused short")
+
+	ErrRevisionNotFound = errors.New("storage layer doesn't have that revision for the short")
+
+	ErrInvalidTemplate = errors.New("invalid parameterized link")
+
+	ErrNotSupported = errors.New("storage doesn't support that")
+	ErrReadOnly     = errors.New("storage is read-only")
+
+	ErrInvalidRemap  = errors.New("invalid regex remap")
+	ErrRegexReadOnly = errors.New("regex remaps can only be changed when they are backed by a file")
 )
 
+// pageListResults trims results (sorted by Link) down to limit, returning the cursor for the next page
+func pageListResults(results []ListResult, limit int) ([]ListResult, string) {
+	if limit <= 0 || len(results) <= limit {
+		return results, ""
+	}
+
+	results = results[:limit]
+	return results, results[limit-1].Link
+}
+
+// revisionURL finds the URL short pointed at in the given revision
+func revisionURL(ctx context.Context, s HistoryStorage, short string, revision string) (string, error) {
+	history, err := s.History(ctx, short)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range history {
+		if entry.Revision == revision {
+			return entry.URL, nil
+		}
+	}
+
+	return "", ErrRevisionNotFound
+}
+
 func validateShort(short string) error {
 	if short == "" {
 		return ErrShortEmpty
@@ -62,7 +267,7 @@ func validateShort(short string) error {
 
 func validateURL(rawURL string) (*url.URL, error) {
 	if rawURL == "" {
-		return nil, ErrShortEmpty
+		return nil, ErrURLEmpty
 	}
 
 	parsedURL, err := url.Parse(rawURL)
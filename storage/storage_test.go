@@ -6,8 +6,11 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/thomasdesr/go-shorten/storage"
 	"github.com/thomasdesr/go-shorten/storage/migrations"
@@ -43,7 +46,7 @@ var storageSetups = map[string]func(testing.TB) storage.NamedStorage{
 	"Inmem": setupInmemStorage,
 	"S3":    setupS3Storage,
 	"S3v3Migration": func(t testing.TB) storage.NamedStorage {
-		return &migrations.S3v2MigrationStore{setupS3Storage(t).(*storage.S3)}
+		return &migrations.S3v2MigrationStore{S3: setupS3Storage(t).(*storage.S3)}
 	},
 	"Filesystem": setupFilesystemStorage,
 	"Postgres":   setupPostgresStorage,
@@ -113,6 +116,57 @@ func TestNamedStorageNormalization(t *testing.T) {
 	}
 }
 
+func TestCanonicalShort(t *testing.T) {
+	testCode := "Test-Canonical-URL"
+	testURL := "http://google.com"
+
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			canonicalStorage, ok := setupStorage(t).(storage.CanonicalStorage)
+			if !ok {
+				t.Skipf("%s doesn't implement storage.CanonicalStorage", name)
+			}
+			namedStorage := canonicalStorage.(storage.NamedStorage)
+
+			err := namedStorage.SaveName(context.Background(), testCode, testURL)
+			assert.Nil(t, err, name)
+
+			short, err := canonicalStorage.CanonicalShort(testCode)
+			assert.Nil(t, err, name)
+
+			url, err := namedStorage.Load(context.Background(), short)
+			assert.Nil(t, err, name)
+			assert.Equal(t, testURL, url, name)
+		})
+	}
+}
+
+func TestValidateLink(t *testing.T) {
+	links := []struct{ short, url string }{
+		{"valid", "http://google.com"},
+		{"relative", "/not/absolute"},
+		{"", "http://google.com"},
+		{"pr/{id}", "https://github.com/org/repo/pull/{number}"},
+		{"c++", "http://cplusplus.com"},
+	}
+
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			namedStorage := setupStorage(t)
+
+			for _, link := range links {
+				validateErr := storage.ValidateLink(namedStorage, link.short, link.url)
+				saveErr := namedStorage.SaveName(context.Background(), link.short, link.url)
+				assert.Equal(t, saveErr == nil, validateErr == nil, "%s: ValidateLink(%q, %q) -> %v, SaveName -> %v", name, link.short, link.url, validateErr, saveErr)
+			}
+		})
+	}
+}
+
 func TestMissingLoad(t *testing.T) {
 	testCode := "non-existent-short-string"
 
@@ -148,6 +202,352 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestUnnamedStorageSave(t *testing.T) {
+	testURL := "http://google.com"
+
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			unnamedStorage, ok := setupStorage(t).(storage.UnnamedStorage)
+			if !assert.True(t, ok, name) {
+				return
+			}
+
+			shortA, err := unnamedStorage.Save(context.Background(), testURL)
+			t.Logf("[%s] unnamedStorage.Save(\"%s\") -> %#v, %#v", name, testURL, shortA, err)
+			assert.Nil(t, err, name)
+			assert.NotEmpty(t, shortA, name)
+
+			shortB, err := unnamedStorage.Save(context.Background(), testURL)
+			assert.Nil(t, err, name)
+			assert.NotEqual(t, shortA, shortB, "Generated shorts should be unique")
+
+			long, err := unnamedStorage.Load(context.Background(), shortA)
+			assert.Nil(t, err, name)
+			assert.Equal(t, testURL, long, name)
+
+			_, err = unnamedStorage.Save(context.Background(), "not-a-url")
+			assert.Equal(t, storage.ErrURLNotAbsolute, err, name)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			deletableStorage, ok := s.(storage.DeletableStorage)
+			if !assert.True(t, ok, name) {
+				return
+			}
+
+			short, _, err := saveSomething(s)
+			assert.Nil(t, err, name)
+
+			err = deletableStorage.Delete(context.Background(), short)
+			t.Logf("[%s] storage.Delete(\"%s\") -> %#v", name, short, err)
+			assert.Nil(t, err, name)
+
+			_, err = deletableStorage.Load(context.Background(), short)
+			assert.Equal(t, storage.ErrShortNotSet, err, name)
+
+			err = deletableStorage.Delete(context.Background(), short)
+			assert.Equal(t, storage.ErrShortNotSet, err, name)
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			metadataStorage, ok := s.(storage.MetadataStorage)
+			if !assert.True(t, ok, name) {
+				return
+			}
+
+			short := randString(10)
+			aliceCtx := storage.WithUser(context.Background(), "alice")
+			bobCtx := storage.WithUser(context.Background(), "bob")
+
+			assert.Nil(t, s.SaveName(aliceCtx, short, "http://a.com"), name)
+			md, err := metadataStorage.Metadata(context.Background(), short)
+			assert.Nil(t, err, name)
+			assert.Equal(t, storage.LinkMetadata{Owner: "alice", LastEditor: "alice"}, md, name)
+
+			assert.Nil(t, s.SaveName(bobCtx, short, "http://b.com"), name)
+			md, err = metadataStorage.Metadata(context.Background(), short)
+			t.Logf("[%s] storage.Metadata(\"%s\") -> %#v, %#v", name, short, md, err)
+			assert.Nil(t, err, name)
+			assert.Equal(t, storage.LinkMetadata{Owner: "alice", LastEditor: "bob"}, md, name)
+
+			_, err = metadataStorage.Metadata(context.Background(), randString(10))
+			assert.Equal(t, storage.ErrShortNotSet, err, name)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			historyStorage, ok := s.(storage.HistoryStorage)
+			if !ok {
+				t.Skipf("%s doesn't keep history", name)
+			}
+
+			short := randString(10)
+			aliceCtx := storage.WithUser(context.Background(), "alice")
+			bobCtx := storage.WithUser(context.Background(), "bob")
+
+			assert.Nil(t, s.SaveName(aliceCtx, short, "http://a.com"), name)
+			assert.Nil(t, s.SaveName(bobCtx, short, "http://b.com"), name)
+
+			history, err := historyStorage.History(context.Background(), short)
+			t.Logf("[%s] storage.History(\"%s\") -> %#v, %#v", name, short, history, err)
+			assert.Nil(t, err, name)
+			if assert.Len(t, history, 2, name) {
+				assert.Equal(t, "http://a.com", history[0].URL, name)
+				assert.Equal(t, "alice", history[0].User, name)
+				assert.Equal(t, "http://b.com", history[1].URL, name)
+				assert.Equal(t, "bob", history[1].User, name)
+				assert.False(t, history[1].Time.Before(history[0].Time), name)
+			}
+
+			_, err = historyStorage.History(context.Background(), randString(10))
+			assert.Equal(t, storage.ErrShortNotSet, err, name)
+		})
+	}
+}
+
+func TestRevert(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			revertableStorage, ok := s.(storage.RevertableStorage)
+			if !ok {
+				t.Skipf("%s doesn't support reverting", name)
+			}
+
+			short := randString(10)
+			assert.Nil(t, s.SaveName(context.Background(), short, "http://a.com"), name)
+			assert.Nil(t, s.SaveName(context.Background(), short, "http://b.com"), name)
+
+			history, err := revertableStorage.History(context.Background(), short)
+			if !assert.Nil(t, err, name) || !assert.Len(t, history, 2, name) {
+				return
+			}
+
+			err = revertableStorage.Revert(storage.WithUser(context.Background(), "carol"), short, history[0].Revision)
+			t.Logf("[%s] storage.Revert(\"%s\", \"%s\") -> %#v", name, short, history[0].Revision, err)
+			assert.Nil(t, err, name)
+
+			long, err := s.Load(context.Background(), short)
+			assert.Nil(t, err, name)
+			assert.Equal(t, "http://a.com", long, name)
+
+			history, err = revertableStorage.History(context.Background(), short)
+			assert.Nil(t, err, name)
+			if assert.Len(t, history, 3, "revert should be recorded in the history") {
+				assert.Equal(t, "http://a.com", history[2].URL, name)
+				assert.Equal(t, "carol", history[2].User, name)
+			}
+
+			err = revertableStorage.Revert(context.Background(), short, "not-a-revision")
+			assert.Equal(t, storage.ErrRevisionNotFound, err, name)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			listableStorage, ok := s.(storage.ListableStorage)
+			if !assert.True(t, ok, name) {
+				return
+			}
+
+			saved := make(map[string]string)
+			for i := 0; i < 5; i++ {
+				short, long, err := saveSomething(s)
+				assert.Nil(t, err, name)
+				saved[strings.ToLower(short)] = long
+			}
+
+			var (
+				listed []storage.ListResult
+				cursor string
+			)
+			for {
+				page, next, err := listableStorage.List(context.Background(), cursor, 2)
+				t.Logf("[%s] storage.List(\"%s\", 2) -> %#v, %#v, %#v", name, cursor, page, next, err)
+				if !assert.Nil(t, err, name) || !assert.True(t, len(page) <= 2, name) {
+					return
+				}
+
+				listed = append(listed, page...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			assert.True(t, sort.SliceIsSorted(listed, func(i, j int) bool {
+				return listed[i].Link < listed[j].Link
+			}), "List should be sorted by short")
+
+			found := make(map[string]string)
+			for _, result := range listed {
+				_, dup := found[result.Link]
+				assert.False(t, dup, "List returned %q twice", result.Link)
+				found[result.Link] = result.URL
+			}
+			for short, long := range saved {
+				assert.Equal(t, long, found[short], name)
+			}
+		})
+	}
+}
+
+func TestParameterizedLinks(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			if name == "Postgres" {
+				t.Skip("Postgres treats every short as a regex instead")
+			}
+			s := setupStorage(t)
+
+			err := s.SaveName(context.Background(), "pr/{id}", "https://github.com/org/repo/pull/{id}")
+			assert.Nil(t, err, name)
+
+			long, err := s.Load(context.Background(), "pr/1234")
+			t.Logf("[%s] storage.Load(\"pr/1234\") -> %#v, %#v", name, long, err)
+			assert.Nil(t, err, name)
+			assert.Equal(t, "https://github.com/org/repo/pull/1234", long, name)
+
+			// Exact links still win over parameterized ones
+			assert.Nil(t, s.SaveName(context.Background(), "pr/1", "http://first.com"), name)
+			long, err = s.Load(context.Background(), "pr/1")
+			assert.Nil(t, err, name)
+			assert.Equal(t, "http://first.com", long, name)
+
+			err = s.SaveName(context.Background(), "pr/{id}", "https://github.com/org/repo/pull/{number}")
+			assert.Equal(t, storage.ErrInvalidTemplate, errors.Cause(err), name)
+		})
+	}
+}
+
+// withFuzzyMatching turns on "did you mean" suggestions for the storages that support them
+func withFuzzyMatching(s storage.NamedStorage) bool {
+	switch s := s.(type) {
+	case *storage.Inmem:
+		s.Fuzzy = storage.DefaultFuzzyMatcher
+	case *storage.Filesystem:
+		s.Fuzzy = storage.DefaultFuzzyMatcher
+	case *storage.S3:
+		s.Fuzzy = storage.DefaultFuzzyMatcher
+	case *migrations.S3v2MigrationStore:
+		s.Fuzzy = storage.DefaultFuzzyMatcher
+	case *storage.Postgres:
+		s.Fuzzy = storage.DefaultFuzzyMatcher
+	default:
+		return false
+	}
+	return true
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			if !withFuzzyMatching(s) {
+				t.Skip("storage doesn't support fuzzy matching")
+			}
+
+			assert.Nil(t, s.SaveName(context.Background(), "kubernetes-docs", "https://kubernetes.io/docs"), name)
+
+			_, err := s.Load(context.Background(), "kubernetes-doc")
+			t.Logf("[%s] storage.Load(\"kubernetes-doc\") -> %#v", name, err)
+			assert.Equal(t, storage.ErrFuzzyMatchFound, errors.Cause(err), name)
+
+			suggestions := storage.Suggestions(err)
+			if assert.Len(t, suggestions, 1, name) {
+				assert.Equal(t, "https://kubernetes.io/docs", suggestions[0].URL, name)
+
+				long, err := s.Load(context.Background(), suggestions[0].Short)
+				assert.Nil(t, err, name)
+				assert.Equal(t, "https://kubernetes.io/docs", long, name)
+			}
+
+			// Nothing close enough
+			_, err = s.Load(context.Background(), "grafana")
+			assert.Equal(t, storage.ErrShortNotSet, errors.Cause(err), name)
+		})
+	}
+}
+
+func searchLinks(results []storage.SearchResult) []string {
+	links := make([]string, 0, len(results))
+	for _, result := range results {
+		links = append(links, result.Link)
+	}
+	return links
+}
+
+func TestSearch(t *testing.T) {
+	for name, setupStorage := range storageSetups {
+		setupStorage := setupStorage
+
+		t.Run(name, func(t *testing.T) {
+			s := setupStorage(t)
+			searchableStorage, ok := s.(storage.SearchableStorage)
+			if !ok {
+				t.Skip("storage doesn't support search")
+			}
+
+			assert.Nil(t, s.SaveName(context.Background(), "grafana", "https://grafana.example.com/dashboards"), name)
+			assert.Nil(t, s.SaveName(context.Background(), "kibana", "https://kibana.example.com"), name)
+
+			results, err := searchableStorage.Search(context.Background(), "grafana")
+			t.Logf("[%s] storage.Search(\"grafana\") -> %#v, %#v", name, results, err)
+			assert.Nil(t, err, name)
+			assert.Contains(t, searchLinks(results), "grafana", name)
+			assert.NotContains(t, searchLinks(results), "kibana", name)
+
+			// Links saved after the first search are found too
+			assert.Nil(t, s.SaveName(context.Background(), "grafanalogs", "https://grafana.example.com/logs"), name)
+			results, err = searchableStorage.Search(context.Background(), "grafana")
+			assert.Nil(t, err, name)
+			assert.Contains(t, searchLinks(results), "grafanalogs", name)
+
+			deletableStorage, ok := s.(storage.DeletableStorage)
+			if !ok {
+				return
+			}
+			assert.Nil(t, deletableStorage.Delete(context.Background(), "grafana"), name)
+			results, err = searchableStorage.Search(context.Background(), "grafana")
+			assert.Nil(t, err, name)
+			assert.NotContains(t, searchLinks(results), "grafana", name)
+		})
+	}
+}
+
 func TestNamedStorageNames(t *testing.T) {
 	var shortNames map[string]error = map[string]error{
 		"simple":             nil,
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Parameterized links look like "pr/{id}" -> "https://github.com/org/repo/pull/{id}". Placeholders have to be whole
+// path segments at the end of the short, and the URL can only use placeholders from the short. They're stored as
+// "pr/{}" -> "https://github.com/org/repo/pull/{1}" so Load can find them without knowing what the placeholders were
+// called, and so that the stored form can be saved again as is (e.g. by an import).
+
+var (
+	templateSuffixRegex      = regexp.MustCompile(`(/?\{[A-Za-z0-9_]*\})+$`)
+	templatePlaceholderRegex = regexp.MustCompile(`\{([A-Za-z0-9_]*)\}`)
+)
+
+// templateShort returns the stored form of a parameterized short along with its placeholder names ("" for unnamed
+// ones). Shorts without placeholders are returned unchanged with no names.
+func templateShort(rawShort string) (string, []string, error) {
+	suffix := templateSuffixRegex.FindString(rawShort)
+	prefix := strings.TrimSuffix(rawShort, suffix)
+
+	if templatePlaceholderRegex.MatchString(prefix) {
+		return "", nil, errors.Wrap(ErrInvalidTemplate, "placeholders have to be at the end of the short")
+	}
+	if suffix == "" {
+		return rawShort, nil, nil
+	}
+	if short, _ := sanitizeShort(prefix); short == "" {
+		return "", nil, errors.Wrap(ErrInvalidTemplate, "short needs something before its placeholders")
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range templatePlaceholderRegex.FindAllStringSubmatch(suffix, -1) {
+		name := match[1]
+		if name != "" && seen[name] {
+			return "", nil, errors.Wrapf(ErrInvalidTemplate, "placeholder {%s} is used more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return strings.TrimSuffix(prefix, "/") + strings.Repeat("/{}", len(names)), names, nil
+}
+
+// canonicalTemplate turns a parameterized short and its URL into the form they're stored in. URLs can refer to
+// placeholders by name or by position ({1} is the first one). Shorts without placeholders are returned unchanged.
+func canonicalTemplate(rawShort string, long string) (string, string, error) {
+	short, names, err := templateShort(rawShort)
+	if err != nil || len(names) == 0 {
+		return short, long, err
+	}
+
+	positions := make(map[string]int, len(names))
+	for i, name := range names {
+		if name != "" {
+			positions[name] = i + 1
+		}
+	}
+
+	var refErr error
+	long = templatePlaceholderRegex.ReplaceAllStringFunc(long, func(ref string) string {
+		name := ref[1 : len(ref)-1]
+		if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(names) {
+			return ref
+		}
+		if position, ok := positions[name]; ok {
+			return fmt.Sprintf("{%d}", position)
+		}
+
+		refErr = errors.Wrapf(ErrInvalidTemplate, "url uses %s which isn't a placeholder in the short", ref)
+		return ref
+	})
+
+	return short, long, refErr
+}
+
+// resolveTemplate looks for a parameterized link matching rawShort using load, which should look up a single stored
+// short without resolving templates itself, and returns its URL with the placeholders filled in.
+func resolveTemplate(ctx context.Context, rawShort string, load func(ctx context.Context, rawShort string) (string, error)) (string, error) {
+	segments := strings.Split(strings.Trim(rawShort, "/"), "/")
+
+	// Prefer the template with the longest literal prefix
+	for k := len(segments) - 1; k >= 1; k-- {
+		key := strings.Join(segments[:k], "/") + strings.Repeat("/{}", len(segments)-k)
+
+		long, err := load(ctx, key)
+		if errors.Cause(err) == ErrShortNotSet {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return expandTemplate(long, segments[k:]), nil
+	}
+
+	return "", ErrShortNotSet
+}
+
+func expandTemplate(long string, args []string) string {
+	return templatePlaceholderRegex.ReplaceAllStringFunc(long, func(ref string) string {
+		n, err := strconv.Atoi(ref[1 : len(ref)-1])
+		if err != nil || n < 1 || n > len(args) {
+			return ref
+		}
+
+		return url.PathEscape(args[n-1])
+	})
+}
+
+// storedShort is the short that a raw short is saved under by the storages that sanitize them
+func storedShort(rawShort string) (string, error) {
+	short, _, err := templateShort(rawShort)
+	if err != nil {
+		return "", err
+	}
+
+	return sanitizeShort(short)
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalTemplate(t *testing.T) {
+	testTable := []struct {
+		name     string
+		short    string
+		url      string
+		outShort string
+		outURL   string
+		err      error
+	}{
+		{name: "not a template",
+			short: "abc", url: "http://a/{x}", outShort: "abc", outURL: "http://a/{x}"},
+		{name: "named placeholder",
+			short: "pr/{id}", url: "https://github.com/pull/{id}", outShort: "pr/{}", outURL: "https://github.com/pull/{1}"},
+		{name: "multiple placeholders out of order",
+			short: "gh/{org}/{repo}", url: "https://github.com/{org}/{repo}?from={org}", outShort: "gh/{}/{}", outURL: "https://github.com/{1}/{2}?from={1}"},
+		{name: "already stored form",
+			short: "pr{}", url: "https://github.com/pull/{1}", outShort: "pr/{}", outURL: "https://github.com/pull/{1}"},
+		{name: "placeholder in the middle",
+			short: "pr/{id}/files", url: "https://github.com/pull/{id}", err: ErrInvalidTemplate},
+		{name: "only placeholders",
+			short: "{id}", url: "https://github.com/pull/{id}", err: ErrInvalidTemplate},
+		{name: "duplicate placeholder",
+			short: "pr/{id}/{id}", url: "https://github.com/pull/{id}", err: ErrInvalidTemplate},
+		{name: "unknown placeholder",
+			short: "pr/{id}", url: "https://github.com/pull/{number}", err: ErrInvalidTemplate},
+	}
+
+	for _, tt := range testTable {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			short, url, err := canonicalTemplate(tt.short, tt.url)
+
+			assert.Equal(t, tt.err, errors.Cause(err))
+			if tt.err == nil {
+				assert.Equal(t, tt.outShort, short)
+				assert.Equal(t, tt.outURL, url)
+			}
+		})
+	}
+}
+
+func TestResolveTemplate(t *testing.T) {
+	stored := map[string]string{
+		"pr/{}":      "https://github.com/pull/{1}",
+		"gh/{}/{}":   "https://github.com/{1}/{2}",
+		"gh/org/{}":  "https://github.com/org/{1}?special",
+		"jira/{}":    "https://atlassian.net/browse/{1}",
+		"search/{}":  "https://google.com/?q={1}",
+		"missing/{}": "https://example.com/{2}",
+	}
+	load := func(ctx context.Context, short string) (string, error) {
+		if url, ok := stored[short]; ok {
+			return url, nil
+		}
+		return "", ErrShortNotSet
+	}
+
+	testTable := []struct {
+		in       string
+		expected string
+		err      error
+	}{
+		{in: "pr/1234", expected: "https://github.com/pull/1234"},
+		{in: "jira/ABC-1", expected: "https://atlassian.net/browse/ABC-1"},
+		{in: "gh/someone/thing", expected: "https://github.com/someone/thing"},
+		{in: "gh/org/thing", expected: "https://github.com/org/thing?special"},
+		{in: "search/a b?c", expected: "https://google.com/?q=a%20b%3Fc"},
+		{in: "missing/x", expected: "https://example.com/{2}"},
+		{in: "pr", err: ErrShortNotSet},
+		{in: "nope/1234", err: ErrShortNotSet},
+	}
+
+	for _, tt := range testTable {
+		t.Logf("Table: %#v", tt)
+		actual, err := resolveTemplate(context.Background(), tt.in, load)
+		assert.Equal(t, tt.err, err, tt.in)
+		assert.Equal(t, tt.expected, actual, tt.in)
+	}
+}
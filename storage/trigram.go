@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+// searchSimilarityThreshold is how similar a short or URL has to be to a search term to match it, the same limit
+// Postgres.Search sets for pg_trgm's % operator
+const searchSimilarityThreshold = 0.2
+
+type trigramSet map[string]struct{}
+
+// trigrams splits s into trigrams the way pg_trgm does: it's lowercased and split into words of letters and digits,
+// then each word is padded with two spaces in front and one behind
+func trigrams(s string) trigramSet {
+	set := make(trigramSet)
+
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+
+	return set
+}
+
+// similarity is how many trigrams a and b share out of how many they have between them, from 0 to 1
+func (a trigramSet) similarity(b trigramSet) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	var shared int
+	for trigram := range a {
+		if _, ok := b[trigram]; ok {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
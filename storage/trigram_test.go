@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestTrigrams(t *testing.T) {
+	var actual []string
+	for trigram := range trigrams("Go-Docs") {
+		actual = append(actual, trigram)
+	}
+	sort.Strings(actual)
+
+	expected := []string{"  d", "  g", " do", " go", "cs ", "doc", "go ", "ocs"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("trigrams(Go-Docs): actual (%q) != expected (%q)", actual, expected)
+	}
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+	testTable := []struct {
+		a, b     string
+		expected float64
+	}{
+		{a: "grafana", b: "grafana", expected: 1},
+		{a: "grafana", b: "kibana", expected: 2.0 / 13},
+		{a: "grafana", b: "", expected: 0},
+		{a: "docs", b: "go-docs", expected: 5.0 / 8},
+	}
+
+	for _, tt := range testTable {
+		if actual := trigrams(tt.a).similarity(trigrams(tt.b)); actual != tt.expected {
+			t.Errorf("similarity(%q, %q): actual (%v) != expected (%v)", tt.a, tt.b, actual, tt.expected)
+		}
+	}
+}
+
+func TestLinkIndexSearch(t *testing.T) {
+	links := []ListResult{
+		{Link: "grafana", URL: "https://grafana.example.com"},
+		{Link: "grafanalogs", URL: "https://logs.example.com"},
+		{Link: "kibana", URL: "https://kibana.example.com"},
+		{Link: "metrics", URL: "https://grafana.example.com/metrics"},
+	}
+
+	var lists int
+	index := newLinkIndex(func(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+		lists++
+		return links, "", nil
+	}, time.Hour)
+
+	results, err := index.search(context.Background(), "grafana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SearchResult{
+		{Link: "grafana", URL: "https://grafana.example.com"},
+		{Link: "grafanalogs", URL: "https://logs.example.com"},
+		{Link: "metrics", URL: "https://grafana.example.com/metrics"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("search(grafana): actual (%v) != expected (%v)", results, expected)
+	}
+
+	index.set("kibana", "https://grafana.example.com/kibana")
+	index.delete("grafanalogs")
+	results, err = index.search(context.Background(), "grafana")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []SearchResult{
+		{Link: "grafana", URL: "https://grafana.example.com"},
+		{Link: "kibana", URL: "https://grafana.example.com/kibana"},
+		{Link: "metrics", URL: "https://grafana.example.com/metrics"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("search(grafana) after set and delete: actual (%v) != expected (%v)", results, expected)
+	}
+	if lists != 1 {
+		t.Errorf("list called %d times, expected once", lists)
+	}
+}
+
+func TestLinkIndexRefresh(t *testing.T) {
+	var (
+		listing = make(chan struct{})
+		release = make(chan struct{})
+		lists   int
+	)
+	index := newLinkIndex(func(ctx context.Context, cursor string, limit int) ([]ListResult, string, error) {
+		if lists++; lists == 2 {
+			// The first refill waits until the test lets it finish
+			listing <- struct{}{}
+			<-release
+		}
+		return []ListResult{{Link: "grafana", URL: "https://grafana.example.com"}}, "", nil
+	}, time.Hour)
+
+	if _, err := index.search(context.Background(), "grafana"); err != nil {
+		t.Fatal(err)
+	}
+	index.loadedAt = time.Time{} // Old enough to refill
+
+	refreshed := make(chan error)
+	go func() {
+		_, err := index.search(context.Background(), "grafana")
+		refreshed <- err
+	}()
+	<-listing
+
+	// While it's being refilled the index can still be searched and changed
+	index.set("kibana", "https://grafana.example.com/kibana")
+	results, err := index.search(context.Background(), "grafana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("search during a refill: actual (%v) should have both links", results)
+	}
+
+	close(release)
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+
+	shorts, err := index.shorts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(shorts)
+	if !reflect.DeepEqual(shorts, []string{"grafana", "kibana"}) {
+		t.Errorf("links set during a refill should be kept, got %v", shorts)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import "context"
+
+type userContextKey struct{}
+
+// WithUser returns a copy of ctx that records user as the person making changes through it
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext returns the user stored by WithUser, or "" if nobody is known
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userContextKey{}).(string)
+	return user
+}
+
+// historyMetadata works out who owns and last changed a link from its history
+func historyMetadata(history []HistoryEntry) LinkMetadata {
+	if len(history) == 0 {
+		return LinkMetadata{}
+	}
+
+	return LinkMetadata{
+		Owner:      history[0].User,
+		LastEditor: history[len(history)-1].User,
+	}
+}
+
+// updateMetadata records user as the last editor of a link, and as the owner if the link doesn't have one yet
+func updateMetadata(md LinkMetadata, user string) LinkMetadata {
+	if md.Owner == "" {
+		md.Owner = user
+	}
+	md.LastEditor = user
+
+	return md
+}
@@ -9,7 +9,9 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-var randChars = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+// randChars are the characters generated shorts are made of. Shorts are lowercased when saved, so there's no point
+// generating uppercase ones.
+var randChars = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
 var randCharsLen = len(randChars)
 
 func getRandomString(length int) string {
@@ -21,3 +23,41 @@ func getRandomString(length int) string {
 
 	return string(s)
 }
+
+// DefaultRandLength is the length of generated shorts for stores that don't configure their own
+const DefaultRandLength = 8
+
+// maxGenerateAttempts bounds how many collisions GenerateShort will put up with before giving up
+const maxGenerateAttempts = 10
+
+// RandLength returns length, or DefaultRandLength if it isn't set
+func RandLength(length int) int {
+	if length <= 0 {
+		return DefaultRandLength
+	}
+
+	return length
+}
+
+// GenerateShort creates random sanitized shorts of the given length and passes them to claim until one of them is
+// successfully claimed. claim should atomically (where the backend allows it) check that the short is unused and save
+// it, returning false if the short was already taken.
+func GenerateShort(length int, claim func(short string) (bool, error)) (string, error) {
+	length = RandLength(length)
+	for i := 0; i < maxGenerateAttempts; i++ {
+		short, err := sanitizeShort(getRandomString(length))
+		if err != nil {
+			return "", err
+		}
+
+		claimed, err := claim(short)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return short, nil
+		}
+	}
+
+	return "", ErrShortGenerationFailed
+}
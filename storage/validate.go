@@ -0,0 +1,38 @@
+package storage
+
+// ValidatingStorage is a Storage with its own rules for which links SaveName accepts
+type ValidatingStorage interface {
+	Storage
+	// ValidateLink returns the error SaveName would for short and url, without saving anything
+	ValidateLink(short string, url string) error
+}
+
+// ValidateLink checks that short and url would be accepted by SaveName on store, without saving anything. Storages
+// without rules of their own are checked with the ones Inmem, Filesystem and S3 use.
+func ValidateLink(store Storage, short string, url string) error {
+	if vs, ok := store.(ValidatingStorage); ok {
+		return vs.ValidateLink(short, url)
+	}
+
+	_, _, err := validateLink(short, url)
+	return err
+}
+
+// validateLink checks a link the way Inmem, Filesystem and S3 do, parameterized links included, returning the short
+// and URL in the form they're saved in
+func validateLink(rawShort string, url string) (string, string, error) {
+	rawShort, url, err := canonicalTemplate(rawShort, url)
+	if err != nil {
+		return "", "", err
+	}
+
+	short, err := sanitizeShort(rawShort)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := validateURL(url); err != nil {
+		return "", "", err
+	}
+
+	return short, url, nil
+}
@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type withoutVisitContextKey struct{}
+
+// WithoutVisit returns a copy of ctx that loads links through it without counting them as visited, for looking links
+// up (e.g. through the API) rather than following them
+func WithoutVisit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutVisitContextKey{}, true)
+}
+
+// countsVisits returns whether loading a link through ctx should count as a visit
+func countsVisits(ctx context.Context) bool {
+	without, _ := ctx.Value(withoutVisitContextKey{}).(bool)
+	return !without
+}